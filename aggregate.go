@@ -0,0 +1,235 @@
+package gitdb
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//AggregateFunc defines the function applied to records by Aggregate
+type AggregateFunc int
+
+const (
+	//AggregateCount counts matching records
+	AggregateCount AggregateFunc = iota + 1
+	//AggregateSum sums the values of Aggregation.Field
+	AggregateSum
+	//AggregateMin returns the smallest value of Aggregation.Field
+	AggregateMin
+	//AggregateMax returns the largest value of Aggregation.Field
+	AggregateMax
+	//AggregateAvg returns the average value of Aggregation.Field
+	AggregateAvg
+)
+
+//Aggregation describes an aggregate computed by Aggregate.
+//Field and GroupBy should be indexes so the aggregate can be computed from the
+//index alone; fields that are not indexed are read from the records instead
+type Aggregation struct {
+	Func AggregateFunc
+	//Field is the field aggregated. It is not required for AggregateCount
+	Field string
+	//GroupBy optionally groups records by the value of a field
+	GroupBy string
+}
+
+//AggregateResult holds the aggregate of a group of records
+type AggregateResult struct {
+	//Group is the value of Aggregation.GroupBy shared by the records in the group
+	Group interface{}
+	//Count is the number of records in the group
+	Count int
+	//Value is the result of Aggregation.Func. Only numeric values of
+	//Aggregation.Field are aggregated
+	Value float64
+
+	numbers int
+}
+
+func (a *Aggregation) validate() error {
+	if a == nil {
+		return errors.New("gitDB: nil aggregation")
+	}
+
+	if a.Func < AggregateCount || a.Func > AggregateAvg {
+		return errors.New("gitDB: invalid aggregate function")
+	}
+
+	if a.Func != AggregateCount && len(a.Field) == 0 {
+		return errors.New("gitDB: aggregation field must be set")
+	}
+
+	return nil
+}
+
+//fields returns the fields needed to compute a
+func (a *Aggregation) fields() []string {
+	var fields []string
+	if a.Func != AggregateCount {
+		fields = append(fields, a.Field)
+	}
+	if len(a.GroupBy) > 0 {
+		fields = append(fields, a.GroupBy)
+	}
+	return fields
+}
+
+//apply computes a over the matching records using the values of
+//fields held in values (field => (recordID => value))
+func (a *Aggregation) apply(matches map[string]string, values map[string]gdbSimpleIndex) []*AggregateResult {
+	groups := map[string]*AggregateResult{}
+	for recordID := range matches {
+		var group interface{}
+		if len(a.GroupBy) > 0 {
+			group = values[a.GroupBy][recordID]
+		}
+
+		key := indexValueString(group)
+		result, ok := groups[key]
+		if !ok {
+			result = &AggregateResult{Group: group}
+			groups[key] = result
+		}
+		result.Count++
+
+		if a.Func == AggregateCount {
+			continue
+		}
+
+		n, ok := values[a.Field][recordID].(float64)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case a.Func == AggregateSum || a.Func == AggregateAvg:
+			result.Value += n
+		case result.numbers == 0:
+			result.Value = n
+		case a.Func == AggregateMin && n < result.Value:
+			result.Value = n
+		case a.Func == AggregateMax && n > result.Value:
+			result.Value = n
+		}
+		result.numbers++
+	}
+
+	results := make([]*AggregateResult, 0, len(groups))
+	for _, result := range groups {
+		switch a.Func {
+		case AggregateCount:
+			result.Value = float64(result.Count)
+		case AggregateAvg:
+			if result.numbers > 0 {
+				result.Value = result.Value / float64(result.numbers)
+			}
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return compareIndexValues(results[i].Group, results[j].Group) < 0
+	})
+
+	return results
+}
+
+//Count returns the number of records in dataset matched by query without reading any blocks
+func (g *gitdb) Count(dataset string, query *Query) (int, error) {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
+
+	matches, _, err := g.match(context.Background(), dataset, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(matches), nil
+}
+
+//Aggregate computes aggregation over records in dataset matched by query
+func (g *gitdb) Aggregate(dataset string, query *Query, aggregation *Aggregation) ([]*AggregateResult, error) {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
+
+	if err := aggregation.validate(); err != nil {
+		return nil, err
+	}
+
+	matches, index, err := g.match(context.Background(), dataset, query)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]gdbSimpleIndex{}
+	var unindexed []string
+	for _, field := range aggregation.fields() {
+		//fields without an index are read from records instead of rebuilding
+		//the indexes of the dataset to look for an index that is never built
+		if !g.isIndexed(dataset, field) {
+			unindexed = append(unindexed, field)
+			continue
+		}
+
+		if values[field] = index(field); values[field] == nil {
+			unindexed = append(unindexed, field)
+		}
+	}
+
+	if len(unindexed) > 0 {
+		log.Info("gitDB: aggregating fields that are not indexed, records will be read")
+		records, err := g.hydrateRecords(context.Background(), matches)
+		if err != nil {
+			return nil, err
+		}
+
+		fieldValues := recordFieldValues(records, unindexed)
+		for _, field := range unindexed {
+			values[field] = fieldValues[field]
+		}
+	}
+
+	return aggregation.apply(matches, values), nil
+}
+
+//isIndexed reports whether field is the id or an index of the schema of the model of dataset
+func (g *gitdb) isIndexed(dataset, field string) bool {
+	if field == "id" {
+		return true
+	}
+
+	model := g.modelFor(dataset)
+	if model == nil {
+		return false
+	}
+
+	_, ok := model.GetSchema().indexes[field]
+	return ok
+}
+
+//recordFieldValues reads fields from records (field => (recordID => value))
+func recordFieldValues(records []*db.Record, fields []string) map[string]gdbSimpleIndex {
+	values := map[string]gdbSimpleIndex{}
+	for _, field := range fields {
+		values[field] = gdbSimpleIndex{}
+	}
+
+	for _, record := range records {
+		var data map[string]interface{}
+		if err := record.Hydrate(&data); err != nil {
+			log.Error(err.Error())
+			continue
+		}
+
+		for _, field := range fields {
+			if v, ok := data[field]; ok {
+				values[field][record.ID()] = v
+			}
+		}
+	}
+
+	return values
+}
@@ -173,6 +173,8 @@ func (m *Message) GetSchema() *gitdb.Schema {
 	//Indexes speed up searching
 	indexes := make(map[string]interface{})
 	indexes["From"] = m.From
+	indexes["MessageId"] = m.MessageId
+	indexes["CreatedAt"] = m.CreatedAt
 
 	return gitdb.NewSchema(name, block, record, indexes)
 }
@@ -216,6 +218,65 @@ func (m *MessageV2) GetLockFileNames() []string { return []string{} }
 
 // func (m *MessageV2) BeforeInsert() error { return nil }
 
+type Customer struct {
+	gitdb.TimeStampedModel
+	CustomerId int
+	Email      string
+}
+
+func (c *Customer) GetSchema() *gitdb.Schema {
+	indexes := make(map[string]interface{})
+	indexes["Email"] = c.Email
+
+	return gitdb.NewSchema("Customer", "b0", fmt.Sprintf("%d", c.CustomerId), indexes, gitdb.UniqueIndex("Email"))
+}
+
+func (c *Customer) Validate() error     { return nil }
+func (c *Customer) ShouldEncrypt() bool { return false }
+
+type Note struct {
+	gitdb.TimeStampedModel
+	NoteId int
+	Title  string
+	Text   string
+}
+
+func (n *Note) GetSchema() *gitdb.Schema {
+	indexes := make(map[string]interface{})
+	indexes["Title"] = n.Title
+	indexes["Text"] = n.Text
+
+	return gitdb.NewSchema("Note", "b0", fmt.Sprintf("%d", n.NoteId), indexes, gitdb.FullText("Title", "Text"))
+}
+
+func (n *Note) Validate() error     { return nil }
+func (n *Note) ShouldEncrypt() bool { return false }
+
+func getTestNotes() []gitdb.Model {
+	return []gitdb.Model{
+		&Note{NoteId: 1, Title: "Late checkout", Text: "Guest asked for a late checkout"},
+		&Note{NoteId: 2, Title: "Refund", Text: "Refund requested, refund approved by manager"},
+		&Note{NoteId: 3, Title: "Allergies", Text: "Guest has a nut allergy, inform the kitchen"},
+		&Note{NoteId: 4, Title: "Refund policy", Text: "Explained the cancellation policy"},
+	}
+}
+
+type LogEntry struct {
+	gitdb.TimeStampedModel
+	EntryId int
+	Text    string
+}
+
+func (l *LogEntry) GetSchema() *gitdb.Schema {
+	indexes := make(map[string]interface{})
+	indexes["Text"] = l.Text
+
+	return gitdb.NewSchema("LogEntry", "2020", fmt.Sprintf("%d", l.EntryId), indexes, gitdb.BlockPolicy(gitdb.BlockByCount, 2))
+}
+
+func (l *LogEntry) Validate() error     { return nil }
+func (l *LogEntry) ShouldEncrypt() bool { return false }
+
 //count the number of records in fetched block
 func countRecords(dataset string) int {
 
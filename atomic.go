@@ -0,0 +1,71 @@
+package gitdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//tempFileSuffix marks files being written by writeFileAtomic. A file with this
+//suffix is only left behind if gitdb stopped in the middle of a write
+const tempFileSuffix = ".gdbtmp"
+
+//writeFileAtomic writes data to a temp file next to filename, syncs it to disk
+//and renames it over filename so filename never holds partially written data
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".*"+tempFileSuffix)
+	if err != nil {
+		return err
+	}
+
+	tmpName := tmp.Name()
+	if err := writeAndSync(tmp, data, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+func writeAndSync(f *os.File, data []byte, perm os.FileMode) error {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+//syncDir persists the rename of a file in dir. Not every platform
+//supports syncing a directory so errors are ignored
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+
+	d.Sync()
+	d.Close()
+}
+
+func isTempFile(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), ".") && strings.HasSuffix(name, tempFileSuffix)
+}
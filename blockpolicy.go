@@ -0,0 +1,180 @@
+package gitdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//BlockPolicy limits the size of the blocks of a dataset. Once the current block
+//holds n records (BlockByCount) or n bytes (BlockBySize) Insert rolls over to a new
+//block named after the schema block with a sequence number e.g 202003~1, 202003~2.
+//Records keep their block, so an updated record is written back to the block it is in
+func BlockPolicy(method BlockMethod, n int64) SchemaOption {
+	return func(s *Schema) {
+		s.blockMethod = method
+		s.blockLimit = n
+	}
+}
+
+//blockFill tracks the blocks rolled over from a schema block
+type blockFill struct {
+	//last is the sequence number of the current block. 0 is the schema block
+	last    int
+	records int
+	size    int64
+	//located maps the record key of each record to the sequence number of its block
+	located map[string]int
+}
+
+//rolloverSeparator separates the schema block from the sequence number in the names of
+//rolled over blocks. Schema blocks cannot contain it so their names never look rolled over
+const rolloverSeparator = "~"
+
+func rolloverBlock(block string, n int) string {
+	if n == 0 {
+		return block
+	}
+	return fmt.Sprintf("%s%s%d", block, rolloverSeparator, n)
+}
+
+//full reports whether the current block has reached the limit of schema
+func (f *blockFill) full(schema *Schema) bool {
+	switch schema.blockMethod {
+	case BlockByCount:
+		return int64(f.records) >= schema.blockLimit
+	case BlockBySize:
+		return f.size >= schema.blockLimit
+	}
+	return false
+}
+
+//loadBlockFill returns the fill of the blocks rolled over from block
+//reading the current block on first use. Caller must hold blockMu
+func (g *gitdb) loadBlockFill(dataset, block string) *blockFill {
+	key := dataset + "/" + block
+	if fill, ok := g.blockFills[key]; ok {
+		return fill
+	}
+
+	fill := &blockFill{located: make(map[string]int)}
+	files, _ := ioutil.ReadDir(filepath.Join(g.dbDir(), dataset))
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".json")
+		if name == file.Name() || !strings.HasPrefix(name, block+rolloverSeparator) {
+			continue
+		}
+
+		if n, err := strconv.Atoi(strings.TrimPrefix(name, block+rolloverSeparator)); err == nil && n > fill.last {
+			fill.last = n
+		}
+	}
+
+	for n := 0; n <= fill.last; n++ {
+		blockFile := g.blockFilePath(dataset, rolloverBlock(block, n))
+		info, err := os.Stat(blockFile)
+		if err != nil {
+			continue
+		}
+
+		b := db.LoadBlock(blockFile, g.keysForFile(blockFile))
+		for _, id := range b.RecordIDs() {
+			if _, _, record, err := ParseID(id); err == nil {
+				fill.located[record] = n
+			}
+		}
+
+		if n == fill.last {
+			fill.size = info.Size()
+			fill.records = b.Len()
+		}
+	}
+
+	if g.blockFills == nil {
+		g.blockFills = make(map[string]*blockFill)
+	}
+	g.blockFills[key] = fill
+	return fill
+}
+
+//blockFor returns the block m must be written to under the block policy of its schema
+func (g *gitdb) blockFor(m Model) string {
+	schema := m.GetSchema()
+	if schema.blockLimit <= 0 {
+		return schema.block
+	}
+
+	g.blockMu.Lock()
+	defer g.blockMu.Unlock()
+
+	//update records where they are
+	fill := g.loadBlockFill(schema.name(), schema.block)
+	if n, ok := fill.located[schema.record]; ok {
+		return rolloverBlock(schema.block, n)
+	}
+
+	if fill.full(schema) {
+		fill.last++
+		fill.records = 0
+		fill.size = 0
+	}
+
+	return rolloverBlock(schema.block, fill.last)
+}
+
+//updateBlockFill records the fill of block after the record of schema has been written to it
+func (g *gitdb) updateBlockFill(schema *Schema, block string, records int, size int64) {
+	if schema.blockLimit <= 0 {
+		return
+	}
+
+	g.blockMu.Lock()
+	defer g.blockMu.Unlock()
+
+	fill := g.loadBlockFill(schema.name(), schema.block)
+	if rolloverBlock(schema.block, fill.last) == block {
+		fill.records = records
+		fill.size = size
+		fill.located[schema.record] = fill.last
+	}
+}
+
+//resetBlockFills discards the tracked fill of the blocks of dataset
+func (g *gitdb) resetBlockFills(dataset string) {
+	g.blockMu.Lock()
+	defer g.blockMu.Unlock()
+
+	for key := range g.blockFills {
+		if strings.HasPrefix(key, dataset+"/") {
+			delete(g.blockFills, key)
+		}
+	}
+}
+
+//resolveID returns the id of the record stored under id. A record written to a block
+//rolled over from the block in id is found under the id of the rolled over block
+func (g *gitdb) resolveID(id string) string {
+	dataset, block, record, err := ParseID(id)
+	if err != nil {
+		return id
+	}
+
+	m := g.modelFor(dataset)
+	if m == nil || m.GetSchema().blockLimit <= 0 {
+		return id
+	}
+
+	g.blockMu.Lock()
+	defer g.blockMu.Unlock()
+
+	if n, ok := g.loadBlockFill(dataset, block).located[record]; ok {
+		return dataset + "/" + rolloverBlock(block, n) + "/" + record
+	}
+
+	return id
+}
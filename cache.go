@@ -0,0 +1,207 @@
+package gitdb
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+const defaultBlockCacheBytes = 64 << 20
+
+//CacheStats reports the state of the block cache of a connection
+type CacheStats struct {
+	//Hits is the number of blocks read from the cache
+	Hits uint64 `json:"hits"`
+	//Misses is the number of blocks read from disk because they were not cached
+	//or their block file changed since they were cached
+	Misses uint64 `json:"misses"`
+	//Evictions is the number of blocks removed to keep the cache within its limits
+	Evictions uint64 `json:"evictions"`
+	//Blocks is the number of blocks in the cache
+	Blocks int `json:"blocks"`
+	//Bytes is the approximate memory held by blocks in the cache
+	Bytes int64 `json:"bytes"`
+	//Indexes is the number of indexes in the index cache
+	Indexes int `json:"indexes"`
+}
+
+//blockCache holds recently used blocks in least recently used order.
+//A cached block is only used while its block file has the size and
+//modification time it had when it was cached
+type blockCache struct {
+	mu        sync.Mutex
+	maxBlocks int
+	maxBytes  int64
+	lru       *list.List
+	entries   map[string]*list.Element
+	stats     CacheStats
+}
+
+type cachedBlock struct {
+	blockFile string
+	block     *db.Block
+	bytes     int64
+	size      int64
+	modTime   time.Time
+}
+
+func newBlockCache(maxBlocks int, maxBytes int64) *blockCache {
+	return &blockCache{
+		maxBlocks: maxBlocks,
+		maxBytes:  maxBytes,
+		lru:       list.New(),
+		entries:   map[string]*list.Element{},
+	}
+}
+
+//get returns the cached block of blockFile or nil if it is not cached or stale
+func (c *blockCache) get(blockFile string, info os.FileInfo) *db.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[blockFile]
+	if !ok {
+		c.stats.Misses++
+		return nil
+	}
+
+	entry := e.Value.(*cachedBlock)
+	if entry.size != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		c.removeElement(e)
+		c.stats.Misses++
+		return nil
+	}
+
+	c.lru.MoveToFront(e)
+	c.stats.Hits++
+	return entry.block
+}
+
+//put caches block as the content of blockFile described by info
+//and evicts least recently used blocks beyond the limits of the cache
+func (c *blockCache) put(blockFile string, block *db.Block, info os.FileInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[blockFile]; ok {
+		c.removeElement(e)
+	}
+
+	entry := &cachedBlock{
+		blockFile: blockFile,
+		block:     block,
+		bytes:     block.MemSize(),
+		size:      info.Size(),
+		modTime:   info.ModTime(),
+	}
+
+	//a block bigger than the cache is not cached
+	if c.maxBytes > 0 && entry.bytes > c.maxBytes {
+		return
+	}
+
+	c.entries[blockFile] = c.lru.PushFront(entry)
+	c.stats.Bytes += entry.bytes
+
+	for (c.maxBlocks > 0 && c.lru.Len() > c.maxBlocks) || (c.maxBytes > 0 && c.stats.Bytes > c.maxBytes) {
+		c.removeElement(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+//remove drops the cached block of blockFile
+func (c *blockCache) remove(blockFile string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[blockFile]; ok {
+		c.removeElement(e)
+	}
+}
+
+//clear drops all cached blocks. Counters are kept
+func (c *blockCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+	c.stats.Bytes = 0
+}
+
+func (c *blockCache) removeElement(e *list.Element) {
+	entry := c.lru.Remove(e).(*cachedBlock)
+	delete(c.entries, entry.blockFile)
+	c.stats.Bytes -= entry.bytes
+}
+
+func (c *blockCache) snapshot() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Blocks = c.lru.Len()
+	return stats
+}
+
+//CacheStats returns the counters of the block cache and the size of the index cache
+func (g *gitdb) CacheStats() CacheStats {
+	stats := g.blockCache.snapshot()
+
+	g.indexMu.Lock()
+	stats.Indexes = len(g.indexCache)
+	g.indexMu.Unlock()
+
+	return stats
+}
+
+//readBlock returns the block at blockFile from the block cache reading it
+//into the cache if it is not cached or its block file has changed
+func (g *gitdb) readBlock(blockFile string) (*db.Block, error) {
+	info, err := os.Stat(blockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if block := g.blockCache.get(blockFile, info); block != nil {
+		return block, nil
+	}
+
+	return g.fillBlock(blockFile, info)
+}
+
+//fillBlock reads the block at blockFile described by info into the block cache
+func (g *gitdb) fillBlock(blockFile string, info os.FileInfo) (*db.Block, error) {
+	block, err := db.ReadBlock(blockFile, g.keysForFile(blockFile))
+	if err != nil {
+		return nil, err
+	}
+
+	g.blockCache.put(blockFile, block, info)
+	return block, nil
+}
+
+//loadBlock returns the block at blockFile to write to.
+//A block file that does not exist yet is an empty block
+func (g *gitdb) loadBlock(blockFile string) (*db.Block, error) {
+	block, err := g.readBlock(blockFile)
+	if os.IsNotExist(err) {
+		return db.NewBlock(blockFile, g.keysForFile(blockFile)), nil
+	}
+
+	return block, err
+}
+
+//cacheBlock caches block after it was written to blockFile
+func (g *gitdb) cacheBlock(blockFile string, block *db.Block) {
+	info, err := os.Stat(blockFile)
+	if err != nil {
+		g.blockCache.remove(blockFile)
+		return
+	}
+
+	g.blockCache.put(blockFile, block, info)
+}
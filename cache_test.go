@@ -0,0 +1,167 @@
+package gitdb_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+func TestBlockCache(t *testing.T) {
+	cfg := getConfig()
+	cfg.BlockCacheBlocks = 2
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("LogEntry", &LogEntry{})
+
+	//6 entries fill blocks 2020, 2020~1 and 2020~2
+	for i := 1; i <= 6; i++ {
+		if err := testDb.Insert(&LogEntry{EntryId: i, Text: fmt.Sprintf("entry %d", i)}); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	stats := testDb.CacheStats()
+	if stats.Blocks != 2 || stats.Evictions != 1 {
+		t.Errorf("testDb.CacheStats after insert want: %d blocks and %d evictions, got: %d blocks and %d evictions",
+			2, 1, stats.Blocks, stats.Evictions)
+	}
+
+	//the last written block is cached
+	entry := &LogEntry{}
+	if err := testDb.Get(gitdb.ID(&LogEntry{EntryId: 6}), entry); err != nil || entry.Text != "entry 6" {
+		t.Errorf("testDb.Get want: %s, got: %s (%v)", "entry 6", entry.Text, err)
+	}
+
+	if got := testDb.CacheStats(); got.Hits != stats.Hits+1 {
+		t.Errorf("testDb.Get of a cached block want: %d hits, got: %d", stats.Hits+1, got.Hits)
+	}
+
+	//reading all blocks evicts the least recently used. Blocks are read in name order
+	//so 2020 evicts 2020~1 before it is read, which then evicts 2020~2
+	stats = testDb.CacheStats()
+	records, err := testDb.Fetch("LogEntry")
+	if err != nil || len(records) != 6 {
+		t.Errorf("testDb.Fetch want: %d records, got: %d (%v)", 6, len(records), err)
+	}
+
+	got := testDb.CacheStats()
+	if got.Blocks != 2 || got.Misses != stats.Misses+3 || got.Evictions != stats.Evictions+3 {
+		t.Errorf("testDb.Fetch want: %d blocks, %d misses and %d evictions, got: %d blocks, %d misses and %d evictions",
+			2, stats.Misses+3, stats.Evictions+3, got.Blocks, got.Misses, got.Evictions)
+	}
+
+	//a block file changed on disk is read again
+	blockFile := filepath.Join(dbPath, "data", "LogEntry", "2020~2.json")
+	changed := time.Now().Add(time.Minute)
+	if err := os.Chtimes(blockFile, changed, changed); err != nil {
+		t.Fatalf("os.Chtimes failed: %s", err)
+	}
+
+	stats = testDb.CacheStats()
+	if err := testDb.Exists(gitdb.ID(&LogEntry{EntryId: 5})); err != nil {
+		t.Errorf("testDb.Exists failed: %s", err)
+	}
+
+	if got := testDb.CacheStats(); got.Misses != stats.Misses+1 {
+		t.Errorf("testDb.Exists of a changed block want: %d misses, got: %d", stats.Misses+1, got.Misses)
+	}
+}
+
+func TestBlockCacheBytes(t *testing.T) {
+	cfg := getConfig()
+	cfg.BlockCacheBytes = 1
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	if err := insert(getTestMessage(), false); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+
+	//blocks bigger than the cache are not cached
+	if stats := testDb.CacheStats(); stats.Blocks != 0 || stats.Bytes != 0 {
+		t.Errorf("testDb.CacheStats want: %d blocks of %d bytes, got: %d blocks of %d bytes", 0, 0, stats.Blocks, stats.Bytes)
+	}
+
+	records, err := testDb.Fetch("Message")
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Fetch want: %d records, got: %d (%v)", 1, len(records), err)
+	}
+}
+
+func TestIndexCacheFiles(t *testing.T) {
+	cfg := getConfig()
+	cfg.IndexCacheFiles = 2
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	//Message has 4 indexes
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessage()); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	if stats := testDb.CacheStats(); stats.Indexes > 2 {
+		t.Errorf("testDb.CacheStats want at most %d indexes, got: %d", 2, stats.Indexes)
+	}
+
+	//evicted indexes are written before they are evicted
+	indexFiles, _ := filepath.Glob(filepath.Join(dbPath, ".gitdb", "index", "Message", "*.idx"))
+	if len(indexFiles) < 2 {
+		t.Errorf("want evicted indexes written, got: %v", indexFiles)
+	}
+
+	records, err := testDb.Search("Message", []*gitdb.SearchParam{{Index: "From", Value: "alice@example.com"}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 3 {
+		t.Errorf("testDb.Search want: %d records, got: %d (%v)", 3, len(records), err)
+	}
+
+	if err := testDb.Delete(gitdb.ID(getTestMessageWithId(1))); err != nil {
+		t.Fatalf("testDb.Delete failed: %s", err)
+	}
+
+	drift, err := testDb.CheckIndex("Message", false)
+	if err != nil || !drift.InSync() {
+		t.Errorf("want indexes in sync, got: %+v (%v)", drift, err)
+	}
+}
+
+func TestIndexUpdateReadsSchemaIndexes(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+	testDb.Close()
+
+	//an index the schema of Message does not have
+	indexPath := filepath.Join(dbPath, ".gitdb", "index", "Message")
+	data, err := ioutil.ReadFile(filepath.Join(indexPath, "From.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(indexPath, "Subject.idx"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Message", &Message{})
+	if err := testDb.Insert(getTestMessageWithId(2)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	if err := testDb.Delete(gitdb.ID(getTestMessageWithId(1))); err != nil {
+		t.Fatalf("testDb.Delete failed: %s", err)
+	}
+
+	//3 indexes of the schema and the id index
+	if stats := testDb.CacheStats(); stats.Indexes != 4 {
+		t.Errorf("testDb.CacheStats want: %d indexes, got: %d", 4, stats.Indexes)
+	}
+}
@@ -20,6 +20,29 @@ var (
 	embedCommand = flag.NewFlagSet("embed", flag.ExitOnError)
 	output       = embedCommand.String("o", "./ui_static.go", "output file name; default ./ui_static.go")
 
+	verifyCommand    = flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyDBPath     = verifyCommand.String("p", "", "path to gitdb")
+	verifyKey        = verifyCommand.String("k", "", "encryption key of gitdb")
+	verifyKeyFile    = verifyCommand.String("key-file", "", "file holding the encryption key of gitdb; default $"+keyEnv)
+	verifyPassphrase = verifyCommand.Bool("passphrase", false, "the key is a passphrase; typed at a prompt if it is not set otherwise")
+	verifyJSON       = verifyCommand.Bool("json", false, "print report as json")
+
+	repairCommand    = flag.NewFlagSet("repair", flag.ExitOnError)
+	repairDBPath     = repairCommand.String("p", "", "path to gitdb")
+	repairKey        = repairCommand.String("k", "", "encryption key of gitdb")
+	repairKeyFile    = repairCommand.String("key-file", "", "file holding the encryption key of gitdb; default $"+keyEnv)
+	repairPassphrase = repairCommand.Bool("passphrase", false, "the key is a passphrase; typed at a prompt if it is not set otherwise")
+	repairRemote     = repairCommand.String("r", "", "online remote to fetch missing objects from")
+	repairJSON       = repairCommand.Bool("json", false, "print report as json")
+
+	rotateCommand    = flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	rotateDBPath     = rotateCommand.String("p", "", "path to gitdb")
+	rotateOldKeyFile = rotateCommand.String("key-file", "", "file holding the current encryption key of gitdb; default $"+keyEnv+" or a prompt")
+	rotateNewKeyFile = rotateCommand.String("new-key-file", "", "file holding the new encryption key of gitdb; default $"+newKeyEnv+" or a prompt")
+	rotatePassphrase = rotateCommand.Bool("passphrase", false, "the keys are passphrases")
+	rotateDataset    = rotateCommand.String("dataset", "", "rotate the key of this dataset only; it keeps the new key as its own key")
+	rotateDatasetKey = rotateCommand.String("dataset-keys", "", "json file holding the keys of datasets with their own key e.g {\"Customer\": \"<key>\"}")
+
 	// dbpath      = flag.String("p", "", "path do gitdb")
 )
 
@@ -33,11 +56,36 @@ func main() {
 		if err != nil {
 			fmt.Println(err.Error())
 		}
+	case "verify":
+		verifyCommand.Parse(os.Args[2:])
+		healthy, err := verify()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(2)
+		}
+		if !healthy {
+			os.Exit(1)
+		}
+	case "repair":
+		repairCommand.Parse(os.Args[2:])
+		resolved, err := repair()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(2)
+		}
+		if !resolved {
+			os.Exit(1)
+		}
+	case "rotate-key":
+		rotateCommand.Parse(os.Args[2:])
+		if err := rotateKey(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(2)
+		}
 	default:
-		fmt.Println("invalid command; try gitdb embed-ui")
+		fmt.Println("invalid command; try gitdb embed-ui, gitdb verify, gitdb repair or gitdb rotate-key")
 		//future commands
 		//clean-db i.e git gc
-		//repair
 		//dataset
 		//dataset <name> blocks
 		//dataset <name> records
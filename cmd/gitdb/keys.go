@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gogitdb/gitdb/v2"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// environment variables the gitdb command reads keys from
+const (
+	keyEnv    = "GITDB_KEY"
+	newKeyEnv = "GITDB_NEW_KEY"
+)
+
+// keyProvider returns the provider of a key read from the file at path, from the
+// environment variable env or typed at prompt, in that order. Keys are never
+// taken from arguments so they do not show up in ps or the shell history
+func keyProvider(path, env, prompt string) gitdb.KeyProvider {
+	if len(path) > 0 {
+		return gitdb.FileKey(path)
+	}
+
+	if _, ok := os.LookupEnv(env); ok {
+		return gitdb.EnvKey(env)
+	}
+
+	return promptKey(prompt)
+}
+
+// dbKey returns the key provider of the gitdb a command works on. A key set with -k is
+// used as is, otherwise it is read from keyFile or $GITDB_KEY. With -passphrase the key is
+// a passphrase, which is typed at a prompt if it is not set otherwise. A nil provider
+// means the gitdb is not encrypted
+func dbKey(key, keyFile string, passphrase bool) (gitdb.KeyProvider, error) {
+	provider := gitdb.StaticKey(key)
+	if len(key) == 0 {
+		if _, ok := os.LookupEnv(keyEnv); !ok && len(keyFile) == 0 && !passphrase {
+			return nil, nil
+		}
+		provider = keyProvider(keyFile, keyEnv, "passphrase")
+	}
+
+	if !passphrase {
+		return provider, nil
+	}
+
+	secret, err := provider.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	return gitdb.PassphraseKey(secret), nil
+}
+
+// datasetKeys reads the keys of datasets with their own key from the json file at path,
+// which maps dataset names to keys
+func datasetKeys(path string) (map[string]string, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]string{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return keys, nil
+}
+
+// promptKey provides a key typed at the terminal without echoing it
+type promptKey string
+
+func (p promptKey) Key() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("%s must be read from a file or the environment when stdin is not a terminal", string(p))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", string(p))
+	key, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if len(key) == 0 {
+		return "", errors.New(string(p) + ": no key entered")
+	}
+
+	return string(key), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+// repair repairs the gitdb at -p, prints what was changed
+// and reports whether every problem was resolved
+func repair() (bool, error) {
+	if len(*repairDBPath) == 0 {
+		return false, errors.New("path to gitdb must be set with -p")
+	}
+
+	if _, err := os.Stat(filepath.Join(*repairDBPath, "data", ".git")); err != nil {
+		return false, err
+	}
+
+	gitdb.SetLogLevel(gitdb.LogLevelNone)
+	cfg := gitdb.NewConfig(*repairDBPath)
+	cfg.ConnectionName = "repair"
+	provider, err := dbKey(*repairKey, *repairKeyFile, *repairPassphrase)
+	if err != nil {
+		return false, err
+	}
+	cfg.KeyProvider = provider
+	cfg.OnlineRemote = *repairRemote
+	// keep the sync clock from touching the repository during a repair
+	cfg.SyncInterval = 24 * time.Hour
+
+	conn, err := gitdb.Open(cfg)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	report, err := conn.Repair()
+	if err != nil {
+		return false, err
+	}
+
+	if *repairJSON {
+		b, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return false, err
+		}
+		fmt.Println(string(b))
+		return len(report.Unresolved) == 0, nil
+	}
+
+	for _, change := range report.Changes {
+		fmt.Println("changed:", change)
+	}
+	for _, problem := range report.Unresolved {
+		fmt.Println("unresolved:", problem)
+	}
+
+	fmt.Printf("%d changes, %d unresolved problems\n", len(report.Changes), len(report.Unresolved))
+	return len(report.Unresolved) == 0, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+// rotateKey re-encrypts the encrypted records of the gitdb at -p or of -dataset with a new key.
+// The current and new keys are read from -key-file and -new-key-file,
+// $GITDB_KEY and $GITDB_NEW_KEY or a prompt. With -passphrase they are passphrases.
+// Datasets with their own key in -dataset-keys keep it unless they are -dataset
+func rotateKey() error {
+	if len(*rotateDBPath) == 0 {
+		return errors.New("path to gitdb must be set with -p")
+	}
+
+	if _, err := os.Stat(filepath.Join(*rotateDBPath, "data", ".git")); err != nil {
+		return err
+	}
+
+	name := "encryption key"
+	if *rotatePassphrase {
+		name = "passphrase"
+	}
+
+	oldKey, err := keyProvider(*rotateOldKeyFile, keyEnv, "current "+name).Key()
+	if err != nil {
+		return err
+	}
+
+	newKey, err := keyProvider(*rotateNewKeyFile, newKeyEnv, "new "+name).Key()
+	if err != nil {
+		return err
+	}
+
+	keys, err := datasetKeys(*rotateDatasetKey)
+	if err != nil {
+		return err
+	}
+
+	gitdb.SetLogLevel(gitdb.LogLevelNone)
+	cfg := gitdb.NewConfig(*rotateDBPath)
+	cfg.ConnectionName = "rotate-key"
+	cfg.DatasetKeys = keys
+	switch {
+	case *rotatePassphrase:
+		cfg.KeyProvider = gitdb.PassphraseKey(oldKey)
+	case len(*rotateDataset) > 0:
+		// the current key is the key of the dataset which may differ from the key of the gitdb
+		cfg.DatasetKeys = map[string]string{*rotateDataset: oldKey}
+		for dataset, key := range keys {
+			if dataset != *rotateDataset {
+				cfg.DatasetKeys[dataset] = key
+			}
+		}
+	default:
+		cfg.EncryptionKey = oldKey
+	}
+	// keep the sync clock from touching the repository during a rotation
+	cfg.SyncInterval = 24 * time.Hour
+
+	conn, err := gitdb.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// keys are derived from the passphrases with the salt of the gitdb
+	if *rotatePassphrase {
+		if oldKey, err = gitdb.DerivePassphraseKey(*rotateDBPath, oldKey); err != nil {
+			return err
+		}
+		if newKey, err = gitdb.DerivePassphraseKey(*rotateDBPath, newKey); err != nil {
+			return err
+		}
+	}
+
+	if len(*rotateDataset) > 0 {
+		err = conn.RotateDatasetKey(*rotateDataset, oldKey, newKey)
+	} else {
+		err = conn.RotateKey(oldKey, newKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("encryption key rotated; sync the database to share the re-encrypted records")
+	return nil
+}
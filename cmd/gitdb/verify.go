@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+// verify prints a report of the problems found in the gitdb at -p
+// and reports whether it is healthy
+func verify() (bool, error) {
+	if len(*verifyDBPath) == 0 {
+		return false, errors.New("path to gitdb must be set with -p")
+	}
+
+	if _, err := os.Stat(filepath.Join(*verifyDBPath, "data")); err != nil {
+		return false, err
+	}
+
+	gitdb.SetLogLevel(gitdb.LogLevelNone)
+	cfg := gitdb.NewConfigWithLocalDriver(*verifyDBPath)
+	cfg.ConnectionName = "verify"
+	provider, err := dbKey(*verifyKey, *verifyKeyFile, *verifyPassphrase)
+	if err != nil {
+		return false, err
+	}
+	cfg.KeyProvider = provider
+
+	//the database is verified without opening a connection so that
+	//files left by a crash are reported instead of being recovered
+	report, err := gitdb.VerifyDB(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	if *verifyJSON {
+		b, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return false, err
+		}
+		fmt.Println(string(b))
+		return report.Healthy(), nil
+	}
+
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+
+	fmt.Printf("%d datasets, %d blocks, %d records: %d errors, %d warnings\n",
+		report.Datasets, len(report.Blocks), report.Records,
+		report.Count(gitdb.SeverityError), report.Count(gitdb.SeverityWarning))
+
+	return report.Healthy(), nil
+}
@@ -0,0 +1,181 @@
+package gitdb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+//these tests are meant to be run with -race
+
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Note", &Note{})
+
+	for i := 0; i < 5; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	run := func(n int, f func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := f(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	//writers
+	run(5, func(i int) error { return testDb.Insert(getTestMessageWithId(100 + i)) })
+	run(5, func(i int) error {
+		return testDb.Insert(&Note{NoteId: i, Title: fmt.Sprintf("note %d", i), Text: "concurrent writes"})
+	})
+	run(1, func(i int) error {
+		return testDb.InsertMany([]gitdb.Model{getTestMessageWithId(200), getTestMessageWithId(201)})
+	})
+	run(1, func(i int) error { return testDb.SetUser(gitdb.NewUser("alice", "alice@example.com")) })
+	run(1, func(i int) error { return testDb.Delete(gitdb.ID(getTestMessageWithId(4))) })
+	run(1, func(i int) error { testDb.RegisterModel("LogEntry", &LogEntry{}); return nil })
+
+	//readers
+	run(10, func(i int) error { return testDb.Get(gitdb.ID(getTestMessageWithId(i%4)), &Message{}) })
+	run(10, func(i int) error {
+		_, err := testDb.Search("Message", []*gitdb.SearchParam{{Index: "From", Value: "alice@example.com"}}, gitdb.SearchEquals)
+		return err
+	})
+	run(10, func(i int) error {
+		_, err := testDb.Fetch("Message")
+		return err
+	})
+	run(5, func(i int) error {
+		_, err := testDb.Count("Message", gitdb.All())
+		return err
+	})
+	run(5, func(i int) error {
+		_, err := testDb.SearchText("Note", "concurrent")
+		if errors.Is(err, gitdb.ErrNoRecords) {
+			return nil
+		}
+		return err
+	})
+	run(5, func(i int) error {
+		return testDb.Iterate("Message", func(*gitdb.Record) error { return nil })
+	})
+	run(5, func(i int) error {
+		_, err := testDb.IndexStatus()
+		testDb.CacheStats()
+		testDb.Config()
+		return err
+	})
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent operation failed: %s", err)
+	}
+
+	//all writes are seen once writers are done
+	want := 5 - 1 + 5 + 2
+	if count, err := testDb.Count("Message", gitdb.All()); err != nil || count != want {
+		t.Errorf("testDb.Count want: %d, got: %d (%v)", want, count, err)
+	}
+
+	if count, err := testDb.Count("Note", gitdb.All()); err != nil || count != 5 {
+		t.Errorf("testDb.Count want: %d, got: %d (%v)", 5, count, err)
+	}
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tx := testDb.StartTransaction(fmt.Sprintf("tx %d", i))
+			for j := 0; j < 3; j++ {
+				m := getTestMessageWithId(i*10 + j)
+				tx.AddOperationContext(func(ctx context.Context) error { return testDb.InsertContext(ctx, m) })
+			}
+
+			if i == 3 {
+				tx.AddOperation(func() error { return errors.New("rollback") })
+			}
+
+			if err := tx.Commit(); err != nil && i != 3 {
+				errs <- err
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("tx.Commit failed: %s", err)
+	}
+
+	//records of committed transactions exist
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if err := testDb.Exists(gitdb.ID(getTestMessageWithId(i*10 + j))); err != nil {
+				t.Errorf("testDb.Exists failed: %s", err)
+			}
+		}
+	}
+}
+
+func TestConcurrentIndexChecks(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 5; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if i%2 == 1 {
+					if _, err := testDb.Verify(); err != nil {
+						errs <- err
+						return
+					}
+					continue
+				}
+
+				if drift, err := testDb.CheckIndex("Message", false); err != nil || !drift.InSync() {
+					errs <- fmt.Errorf("testDb.CheckIndex want: in sync, got: %+v (%v)", drift, err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -3,6 +3,9 @@ package gitdb
 import (
 	"errors"
 	"time"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
 // Config represents configuration options for GitDB
@@ -16,6 +19,33 @@ type Config struct {
 	Factory        func(string) Model
 	EnableUI       bool
 	UIPort         int
+	// BlockFormat is the format blocks are written in unless a
+	// dataset's schema sets one. Defaults to BlockFormatJSON
+	BlockFormat BlockFormat
+	// BlockCodec compresses blocks unless a dataset's schema
+	// sets a codec. Defaults to BlockCodecNone
+	BlockCodec BlockCodec
+	// BlockCacheBlocks is the maximum number of blocks a connection keeps in memory.
+	// Zero means no limit on the number of blocks
+	BlockCacheBlocks int
+	// BlockCacheBytes is the maximum approximate memory in bytes held by cached blocks.
+	// Zero means no limit unless BlockCacheBlocks is also zero in which case it defaults to 64MB
+	BlockCacheBytes int64
+	// IndexCacheFiles is the maximum number of index files a connection keeps in memory.
+	// The least recently used are written and evicted first. Defaults to 256 and a
+	// negative value means no limit
+	IndexCacheFiles int
+	// FailOnIndexBuild makes queries fail with ErrIndexBuilding while an index
+	// is being built instead of waiting for the build to finish
+	FailOnIndexBuild bool
+	// KeyRing holds earlier encryption keys. Records encrypted with any of them
+	// are read but records are only encrypted with EncryptionKey
+	KeyRing []string
+	// KeyProvider provides the encryption key instead of EncryptionKey
+	KeyProvider KeyProvider
+	// DatasetKeys holds the encryption keys of datasets. Records of a dataset
+	// with a key are encrypted with it instead of EncryptionKey
+	DatasetKeys map[string]string
 	// Mock is a hook for testing apps. If true will return a Mock DB connection
 	Mock   bool
 	Driver dbDriver
@@ -26,6 +56,7 @@ const defaultSyncInterval = time.Second * 5
 const defaultUserName = "ghost"
 const defaultUserEmail = "ghost@gitdb.local"
 const defaultUIPort = 4120
+const defaultIndexCacheFiles = 256
 
 // NewConfig constructs a *Config
 func NewConfig(dbPath string) *Config {
@@ -57,5 +88,33 @@ func (c *Config) Validate() error {
 		return errors.New("Config.DbPath must be set")
 	}
 
+	if len(c.BlockFormat) > 0 && !db.Format(c.BlockFormat).Valid() {
+		return errors.New("Config.BlockFormat is not a valid block format")
+	}
+
+	if len(c.BlockCodec) > 0 && !db.Codec(c.BlockCodec).Valid() {
+		return errors.New("Config.BlockCodec is not a valid block codec")
+	}
+
+	for _, key := range append([]string{c.EncryptionKey}, c.KeyRing...) {
+		if len(key) > 0 && crypto.ValidKey(key) != nil {
+			return ErrEncryptionKey
+		}
+	}
+
+	for _, key := range c.DatasetKeys {
+		if crypto.ValidKey(key) != nil {
+			return ErrEncryptionKey
+		}
+	}
+
+	if len(c.EncryptionKey) > 0 && c.KeyProvider != nil {
+		return errors.New("Config.EncryptionKey and Config.KeyProvider cannot both be set")
+	}
+
+	if c.BlockCacheBlocks < 0 || c.BlockCacheBytes < 0 {
+		return errors.New("Config.BlockCacheBlocks and Config.BlockCacheBytes must not be negative")
+	}
+
 	return nil
 }
@@ -0,0 +1,106 @@
+package gitdb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bouggo/log"
+)
+
+//lockContext acquires l unless ctx is done first. If ctx is done while l is
+//held by someone else, l is released as soon as it is acquired
+func lockContext(ctx context.Context, l sync.Locker) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	//ctx can never be done
+	if ctx.Done() == nil {
+		l.Lock()
+		return nil
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		l.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			l.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+//lockConn takes the write lock of the connection unless ctx is done first.
+//The operations of a running transaction use the lock the transaction holds.
+//It returns the func that releases the lock
+func (g *gitdb) lockConn(ctx context.Context) (func(), error) {
+	if g.inTransaction(ctx) {
+		return func() {}, nil
+	}
+
+	if err := lockContext(ctx, &g.connMu); err != nil {
+		return nil, err
+	}
+	return g.connMu.Unlock, nil
+}
+
+//rlockConn is lockConn for the read lock of the connection
+func (g *gitdb) rlockConn(ctx context.Context) (func(), error) {
+	if g.inTransaction(ctx) {
+		return func() {}, nil
+	}
+
+	if err := lockContext(ctx, g.connMu.RLocker()); err != nil {
+		return nil, err
+	}
+	return g.connMu.RUnlock, nil
+}
+
+//notify sends e to the event loop unless ctx is done first
+func (g *gitdb) notify(ctx context.Context, e *dbEvent) {
+	select {
+	case g.events <- e:
+	case <-ctx.Done():
+	}
+}
+
+//commitEvent sends the write or delete event e to the event loop and blocks
+//until it has been handled or ctx is done. Once sent, e is handled by the
+//event loop even if ctx is done so writes on disk are always committed.
+//A done ctx is not an error here: the write already happened and a caller
+//retrying it would write it again
+func (g *gitdb) commitEvent(ctx context.Context, e *dbEvent) error {
+	g.commit.Add(1)
+	select {
+	case g.events <- e:
+	case <-ctx.Done():
+		go func() { g.events <- e }()
+		return nil
+	}
+
+	g.waitForCommit(ctx, e)
+	return nil
+}
+
+//waitForCommit blocks until e has been committed or ctx is done.
+//Events of a transaction are not committed so they are not waited for
+func (g *gitdb) waitForCommit(ctx context.Context, e *dbEvent) {
+	if !e.Commit {
+		return
+	}
+
+	log.Test("waiting for gitdb to commit changes")
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		log.Test("stopped waiting for commit: " + ctx.Err().Error())
+	}
+}
@@ -0,0 +1,243 @@
+package gitdb_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+func TestContext(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	m := getTestMessageWithId(1)
+	if err := testDb.InsertContext(ctx, m); err != nil {
+		t.Fatalf("testDb.InsertContext failed: %s", err)
+	}
+
+	if err := testDb.GetContext(ctx, gitdb.ID(m), &Message{}); err != nil {
+		t.Errorf("testDb.GetContext failed: %s", err)
+	}
+
+	records, err := testDb.SearchContext(ctx, "Message", []*gitdb.SearchParam{{Index: "From", Value: m.From}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.SearchContext want: 1 record, got: %d (%v)", len(records), err)
+	}
+
+	if err := testDb.LockContext(ctx, m); err != nil {
+		t.Errorf("testDb.LockContext failed: %s", err)
+	}
+
+	if err := testDb.UnlockContext(ctx, m); err != nil {
+		t.Errorf("testDb.UnlockContext failed: %s", err)
+	}
+
+	if err := testDb.DeleteContext(ctx, gitdb.ID(m)); err != nil {
+		t.Errorf("testDb.DeleteContext failed: %s", err)
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	m := getTestMessageWithId(1)
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := map[string]func() error{
+		"InsertContext":     func() error { return testDb.InsertContext(ctx, getTestMessageWithId(2)) },
+		"InsertManyContext": func() error { return testDb.InsertManyContext(ctx, []gitdb.Model{getTestMessageWithId(3)}) },
+		"GetContext":        func() error { return testDb.GetContext(ctx, gitdb.ID(m), &Message{}) },
+		"FetchContext": func() error {
+			_, err := testDb.FetchContext(ctx, "Message")
+			return err
+		},
+		"SearchContext": func() error {
+			_, err := testDb.SearchContext(ctx, "Message", []*gitdb.SearchParam{{Index: "From", Value: m.From}}, gitdb.SearchEquals)
+			return err
+		},
+		"FindContext": func() error {
+			_, err := testDb.FindContext(ctx, "Message", gitdb.All())
+			return err
+		},
+		"DeleteContext": func() error { return testDb.DeleteContext(ctx, gitdb.ID(m)) },
+		"LockContext":   func() error { return testDb.LockContext(ctx, m) },
+		"UnlockContext": func() error { return testDb.UnlockContext(ctx, m) },
+		"SyncContext":   func() error { return testDb.SyncContext(ctx) },
+	}
+
+	for name, f := range tests {
+		if err := f(); !errors.Is(err, context.Canceled) {
+			t.Errorf("testDb.%s want: %s, got: %v", name, context.Canceled, err)
+		}
+	}
+
+	//nothing was written or deleted
+	if count, err := testDb.Count("Message", gitdb.All()); err != nil || count != 1 {
+		t.Errorf("testDb.Count want: 1, got: %d (%v)", count, err)
+	}
+}
+
+func TestContextDeadlineWhileLocked(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	//a running transaction keeps InsertMany waiting
+	release := make(chan struct{})
+	started := make(chan struct{})
+	committed := make(chan error)
+	tx := testDb.StartTransaction("slow")
+	tx.AddOperationContext(func(ctx context.Context) error {
+		close(started)
+		<-release
+		return testDb.InsertContext(ctx, getTestMessageWithId(1))
+	})
+	go func() { committed <- tx.Commit() }()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := testDb.InsertManyContext(ctx, []gitdb.Model{getTestMessageWithId(2)}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("testDb.InsertManyContext want: %s, got: %v", context.DeadlineExceeded, err)
+	}
+
+	close(release)
+	if err := <-committed; err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+
+	//the connection is usable once the transaction is done
+	if err := testDb.InsertManyContext(context.Background(), []gitdb.Model{getTestMessageWithId(2)}); err != nil {
+		t.Errorf("testDb.InsertManyContext failed: %s", err)
+	}
+}
+
+func TestSyncContextKillsGit(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	//make fetching from the online remote hang
+	cmd := exec.Command("git", "-C", filepath.Join(dbPath, "data"), "config", "remote.online.uploadpack", "exec 2>/dev/null; sleep 30; git-upload-pack")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git config failed: %s", out)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := testDb.SyncContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("testDb.SyncContext want: %s, got: %v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("testDb.SyncContext returned after %s", elapsed)
+	}
+}
+
+func TestSyncDoesNotHoldConnectionWhileFetching(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	//make fetching from the online remote hang
+	cmd := exec.Command("git", "-C", filepath.Join(dbPath, "data"), "config", "remote.online.uploadpack", "exec 2>/dev/null; sleep 30; git-upload-pack")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git config failed: %s", out)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	synced := make(chan error)
+	go func() { synced <- testDb.SyncContext(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	//reads and writes go on while git fetches
+	start := time.Now()
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Errorf("testDb.Insert failed: %s", err)
+	}
+	if err := testDb.Get(gitdb.ID(getTestMessageWithId(1)), &Message{}); err != nil {
+		t.Errorf("testDb.Get failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("testDb.Insert and testDb.Get waited %s for the sync", elapsed)
+	}
+
+	cancel()
+	if err := <-synced; !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.SyncContext want: %s, got: %v", context.Canceled, err)
+	}
+}
+
+//cancelingMessage cancels the context it is inserted with once the connection is locked
+type cancelingMessage struct {
+	Message
+	cancel func()
+}
+
+func (m *cancelingMessage) BeforeInsert() error {
+	m.cancel()
+	return m.Message.BeforeInsert()
+}
+
+func TestContextCanceledDuringInsert(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := &cancelingMessage{Message: *getTestMessageWithId(2), cancel: cancel}
+	if err := testDb.InsertContext(ctx, m); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.InsertContext want: %s, got: %v", context.Canceled, err)
+	}
+
+	//the canceled record is not found in the block cache
+	if err := testDb.Get(gitdb.ID(&m.Message), &Message{}); err == nil {
+		t.Error("testDb.Get found a record whose insert was canceled")
+	}
+
+	if records, err := testDb.Fetch("Message"); err != nil || len(records) != 1 {
+		t.Errorf("testDb.Fetch want: 1 record, got: %d (%v)", len(records), err)
+	}
+}
+
+func TestContextDoneAfterInsertWritten(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	//make committing the written record outlast the context
+	hook := filepath.Join(dbPath, "data", ".git", "hooks", "pre-commit")
+	if err := ioutil.WriteFile(hook, []byte("#!/bin/sh\nsleep 2\n"), 0755); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	//the record is written before the context is done so the insert succeeds
+	m := getTestMessageWithId(1)
+	if err := testDb.InsertContext(ctx, m); err != nil {
+		t.Errorf("testDb.InsertContext want: nil, got: %s", err)
+	}
+
+	if err := testDb.Get(gitdb.ID(m), &Message{}); err != nil {
+		t.Errorf("testDb.Get failed: %s", err)
+	}
+}
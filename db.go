@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
@@ -26,27 +28,63 @@ const (
 	SearchStartsWith SearchMode = 3
 	// SearchEndsWith will search index for records whose values ends with SearchParam.Value
 	SearchEndsWith SearchMode = 4
+	// SearchGreaterThan will search index for records whose values are greater than SearchParam.Value
+	SearchGreaterThan SearchMode = 5
+	// SearchLessThan will search index for records whose values are less than SearchParam.Value
+	SearchLessThan SearchMode = 6
+	// SearchBetween will search index for records whose values are between SearchParam.Value and SearchParam.To (inclusive)
+	SearchBetween SearchMode = 7
+	// SearchNotEquals will search index for records whose values do not equal SearchParam.Value
+	SearchNotEquals SearchMode = 8
 )
 
 // SearchParam represents search parameters against GitDB index
 type SearchParam struct {
 	Index string
 	Value string
+	// To is the upper bound of a SearchBetween search
+	To string
 }
 
+// Record is a record of a dataset as returned by Fetch, Search and Iterate
+type Record = db.Record
+
 // GitDb interface defines all exported funcs an implementation must have
 type GitDb interface {
 	Close() error
 	Insert(m Model) error
+	InsertContext(ctx context.Context, m Model) error
 	InsertMany(m []Model) error
+	InsertManyContext(ctx context.Context, m []Model) error
 	Get(id string, m Model) error
+	GetContext(ctx context.Context, id string, m Model) error
 	Exists(id string) error
 	Fetch(dataset string, block ...string) ([]*db.Record, error)
+	FetchContext(ctx context.Context, dataset string, block ...string) ([]*db.Record, error)
+	Iterate(dataset string, fn func(*db.Record) error) error
 	Search(dataDir string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error)
+	SearchContext(ctx context.Context, dataDir string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error)
+	Find(dataset string, query *Query) ([]*db.Record, error)
+	FindContext(ctx context.Context, dataset string, query *Query) ([]*db.Record, error)
+	FindPage(dataset string, query *Query) (*Page, error)
+	FindPageContext(ctx context.Context, dataset string, query *Query) (*Page, error)
+	SearchText(dataset string, text string) ([]*db.Record, error)
+	Count(dataset string, query *Query) (int, error)
+	Aggregate(dataset string, query *Query, aggregation *Aggregation) ([]*AggregateResult, error)
+	CheckIndex(dataset string, repair bool) (*IndexDrift, error)
+	Reindex(dataset ...string) error
+	IndexStatus() ([]*IndexStatus, error)
+	Verify() (*VerifyReport, error)
+	Repair() (*RepairReport, error)
+	RotateKey(oldKey, newKey string) error
+	RotateDatasetKey(dataset, oldKey, newKey string) error
 	Delete(id string) error
+	DeleteContext(ctx context.Context, id string) error
 	DeleteOrFail(id string) error
 	Lock(m Model) error
+	LockContext(ctx context.Context, m Model) error
 	Unlock(m Model) error
+	UnlockContext(ctx context.Context, m Model) error
 	Upload() *Upload
 	Migrate(from Model, to Model) error
 	GetMails() []*mail
@@ -54,30 +92,62 @@ type GitDb interface {
 	GetLastCommitTime() (time.Time, error)
 	SetUser(user *User) error
 	Config() Config
+	CacheStats() CacheStats
 	Sync() error
+	SyncContext(ctx context.Context) error
 	RegisterModel(dataset string, m Model) bool
 }
 
 type gitdb struct {
+	// connMu lets reads run concurrently and serializes writes. Exported
+	// methods take it and unexported methods expect the caller to hold it
+	connMu   sync.RWMutex
+	txMu     sync.Mutex
+	syncMu   sync.Mutex
+	regMu    sync.RWMutex
+	mailMu   sync.Mutex
 	mu       sync.Mutex
 	indexMu  sync.Mutex
 	writeMu  sync.Mutex
-	syncMu   sync.Mutex
+	buildMu  sync.Mutex
+	blockMu  sync.Mutex
 	commit   sync.WaitGroup
 	locked   chan bool
 	shutdown chan bool
 	events   chan *dbEvent
 
 	config Config
-	driver dbDriver
-
-	autoCommit   bool
-	indexUpdated bool
-	loopStarted  bool
-	closed       bool
-
-	indexCache   gdbSimpleIndexCache
-	loadedBlocks map[string]*db.Block
+	keys   *crypto.KeyRing
+	// datasetKeys encrypt the records of datasets with their own key
+	datasetKeys map[string]*crypto.KeyRing
+	driver      dbDriver
+
+	autoCommit  bool
+	loopStarted bool
+	closed      bool
+	// readOnly connections never write index files e.g the one of VerifyDB
+	readOnly bool
+	// stopSync cancels the sync of the sync clock
+	stopSync context.CancelFunc
+
+	indexCache     gdbSimpleIndexCache
+	dirtyIndexes   map[string]bool
+	textIndexCache gdbTextIndexCache
+	uniqueIndexes  map[string]*uniqueValues
+	positionCache  gdbIndexCache
+	blockCache     *blockCache
+	txDatasets     map[string]bool
+	indexedAt      map[string]time.Time
+	builds         map[string]*indexBuild
+	blockFills     map[string]*blockFill
+
+	// indexUsed holds when cached indexes were last used
+	// so that the least recently used are evicted first
+	indexUsed map[string]uint64
+	indexTick uint64
+
+	// recovered holds the files recovered when the connection was opened
+	recovered []*RepairChange
 
 	mails    []*mail
 	registry map[string]Model
@@ -85,7 +155,7 @@ type gitdb struct {
 
 func newConnection() *gitdb {
 	// autocommit defaults to true
-	db := &gitdb{autoCommit: true, indexCache: make(gdbSimpleIndexCache)}
+	db := &gitdb{autoCommit: true, indexCache: make(gdbSimpleIndexCache), textIndexCache: make(gdbTextIndexCache)}
 	// initialize channels
 	db.events = make(chan *dbEvent, 1)
 	db.locked = make(chan bool, 1)
@@ -98,6 +168,8 @@ func newConnection() *gitdb {
 }
 
 func (g *gitdb) Config() Config {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
 	return g.config
 }
 
@@ -108,23 +180,38 @@ func (g *gitdb) Close() error {
 
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	log.Test("shutting down gitdb")
 	if g.closed {
 		log.Test("connection already closed")
 		return nil
 	}
 
-	// flush index to disk
+	// syncs fetch and push without the connection so wait for the one in progress
+	if g.stopSync != nil {
+		g.stopSync()
+	}
+	g.syncMu.Lock()
+	g.syncMu.Unlock()
+
+	g.txMu.Lock()
+	defer g.txMu.Unlock()
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
+	log.Test("shutting down gitdb")
+
+	// flush index to disk once background builds are done
+	g.waitForBuilds()
 	if err := g.flushIndex(); err != nil {
 		return err
 	}
 
-	// send shutdown event to event loop and sync clock
-	g.shutdown <- true
-	g.waitForCommit()
+	// wait for pending commits then stop the event loop, sync clock and UI server
+	g.commit.Wait()
+	close(g.shutdown)
 
 	// remove cached connection
+	connsMu.Lock()
 	delete(conns, g.config.ConnectionName)
+	connsMu.Unlock()
 	g.closed = true
 	log.Info("closed gitdb conn")
 	return nil
@@ -143,6 +230,20 @@ func (g *gitdb) configure(cfg Config) {
 		cfg.UIPort = defaultUIPort
 	}
 
+	if cfg.IndexCacheFiles == 0 {
+		cfg.IndexCacheFiles = defaultIndexCacheFiles
+	}
+
+	if cfg.BlockCacheBlocks == 0 && cfg.BlockCacheBytes == 0 {
+		cfg.BlockCacheBytes = defaultBlockCacheBytes
+	}
+	g.blockCache = newBlockCache(cfg.BlockCacheBlocks, cfg.BlockCacheBytes)
+	g.keys = crypto.NewKeyRing(cfg.EncryptionKey, cfg.KeyRing...)
+	g.datasetKeys = make(map[string]*crypto.KeyRing)
+	for dataset, key := range cfg.DatasetKeys {
+		g.datasetKeys[dataset] = crypto.NewKeyRing(key)
+	}
+
 	g.driver = cfg.Driver
 	if cfg.Driver == nil {
 		g.driver = &gitDriver{driver: &gitBinaryDriver{}}
@@ -153,6 +254,10 @@ func (g *gitdb) configure(cfg Config) {
 
 // Migrate model from one schema to another
 func (g *gitdb) Migrate(from Model, to Model) error {
+	g.txMu.Lock()
+	defer g.txMu.Unlock()
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
 
 	// TODO add test case for this
 	// schema has not changed
@@ -160,12 +265,13 @@ func (g *gitdb) Migrate(from Model, to Model) error {
 		return errors.New("Invalid migration - no change found in schema")
 	}*/
 
-	block := db.NewEmptyBlock(g.config.EncryptionKey)
-	if err := g.doFetch(from.GetSchema().name(), block); err != nil {
+	block := db.NewEmptyBlock(g.keysFor(from.GetSchema().name()))
+	if err := g.doFetch(context.Background(), from.GetSchema().name(), block); err != nil {
 		return err
 	}
 
 	oldBlocks := map[string]string{}
+	var oldRecords []string
 	var migrate []Model
 	for _, record := range block.Records() {
 		dataset, blockID, _, _ := ParseID(record.ID())
@@ -173,6 +279,7 @@ func (g *gitdb) Migrate(from Model, to Model) error {
 			blockFilePath := filepath.Join(g.dbDir(), dataset, blockID+".json")
 			oldBlocks[blockID] = blockFilePath
 		}
+		oldRecords = append(oldRecords, record.ID())
 
 		if err := record.Hydrate(to); err != nil {
 			return err
@@ -181,8 +288,8 @@ func (g *gitdb) Migrate(from Model, to Model) error {
 		migrate = append(migrate, to)
 	}
 
-	// InsertMany will rollback if any insert fails
-	if err := g.InsertMany(migrate); err != nil {
+	// insertMany will rollback if any insert fails
+	if err := g.insertMany(context.Background(), migrate); err != nil {
 		return err
 	}
 
@@ -195,6 +302,9 @@ func (g *gitdb) Migrate(from Model, to Model) error {
 		}
 	}
 
+	//records in removed blocks no longer exist
+	g.removeFromIndexes(from.GetSchema().name(), oldRecords...)
+
 	return nil
 }
 
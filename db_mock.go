@@ -1,18 +1,23 @@
 package gitdb
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
 type mockdb struct {
+	mu     sync.RWMutex
 	config Config
 	data   map[string]Model
 	index  map[string]map[string]interface{}
@@ -21,13 +26,13 @@ type mockdb struct {
 
 type mocktransaction struct {
 	name       string
-	operations []operation
+	operations []contextOperation
 	db         *mockdb
 }
 
 func (t *mocktransaction) Commit() error {
 	for _, o := range t.operations {
-		if err := o(); err != nil {
+		if err := o(context.Background()); err != nil {
 			log.Info("Reverting transaction: " + err.Error())
 			return err
 		}
@@ -36,6 +41,10 @@ func (t *mocktransaction) Commit() error {
 }
 
 func (t *mocktransaction) AddOperation(o operation) {
+	t.operations = append(t.operations, func(context.Context) error { return o() })
+}
+
+func (t *mocktransaction) AddOperationContext(o contextOperation) {
 	t.operations = append(t.operations, o)
 }
 
@@ -49,11 +58,39 @@ func newMockConnection() *mockdb {
 }
 
 func (g *mockdb) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	g.data = nil
 	return nil
 }
 
 func (g *mockdb) Insert(m Model) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.insert(m)
+}
+
+func (g *mockdb) InsertContext(ctx context.Context, m Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.Insert(m)
+}
+
+func (g *mockdb) insert(m Model) error {
+	schema := m.GetSchema()
+	for name := range schema.unique {
+		value := normalizeIndexValue(schema.indexes[name])
+		for recordID, iv := range g.index[schema.dataset+"."+name] {
+			if recordID != ID(m) && value != nil && value != "" && compareIndexValues(iv, value) == 0 {
+				return &UniqueViolationError{Index: name, Value: value, RecordID: recordID}
+			}
+		}
+	}
+
+	g.delete(ID(m))
 	g.data[ID(m)] = m
 
 	for name, value := range m.GetSchema().indexes {
@@ -61,20 +98,41 @@ func (g *mockdb) Insert(m Model) error {
 		if _, ok := g.index[key]; !ok {
 			g.index[key] = make(map[string]interface{})
 		}
-		g.index[key][ID(m)] = value
+		g.index[key][ID(m)] = normalizeIndexValue(value)
 	}
 
 	return nil
 }
 
 func (g *mockdb) InsertMany(m []Model) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	for _, model := range m {
-		g.Insert(model)
+		if err := g.insert(model); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+func (g *mockdb) InsertManyContext(ctx context.Context, m []Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.InsertMany(m)
+}
+
+func (g *mockdb) GetContext(ctx context.Context, id string, result Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.Get(id, result)
+}
+
 func (g *mockdb) Get(id string, result Model) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
 
 	if reflect.ValueOf(result).Kind() != reflect.Ptr || reflect.ValueOf(result).IsNil() {
 		return errors.New("Second argument to Get must be a non-nil pointer")
@@ -101,6 +159,9 @@ func (g *mockdb) Get(id string, result Model) error {
 }
 
 func (g *mockdb) Exists(id string) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
 	_, exists := g.data[id]
 	if !exists {
 		dataset, _, _, _ := ParseID(id)
@@ -110,7 +171,36 @@ func (g *mockdb) Exists(id string) error {
 	return nil
 }
 
+func (g *mockdb) Iterate(dataset string, fn func(*db.Record) error) error {
+	records, err := g.Fetch(dataset)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].ID() < records[j].ID() })
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *mockdb) FetchContext(ctx context.Context, dataset string, blocks ...string) ([]*db.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.Fetch(dataset, blocks...)
+}
+
 func (g *mockdb) Fetch(dataset string, blocks ...string) ([]*db.Record, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
 	var result []*db.Record
 	blockStream := "|" + strings.Join(blocks, "|") + "|"
 	for id, model := range g.data {
@@ -135,50 +225,276 @@ func (g *mockdb) Fetch(dataset string, blocks ...string) ([]*db.Record, error) {
 }
 
 func (g *mockdb) Search(dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	return g.Find(dataset, searchQuery(searchParams, searchMode))
+}
+
+func (g *mockdb) SearchContext(ctx context.Context, dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	return g.FindContext(ctx, dataset, searchQuery(searchParams, searchMode))
+}
+
+func (g *mockdb) FindContext(ctx context.Context, dataset string, query *Query) ([]*db.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.Find(dataset, query)
+}
+
+func (g *mockdb) Find(dataset string, query *Query) ([]*db.Record, error) {
+	page, err := g.FindPage(dataset, query)
+	if err != nil {
+		return nil, err
+	}
+	return page.Records, nil
+}
+
+func (g *mockdb) FindPageContext(ctx context.Context, dataset string, query *Query) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.FindPage(dataset, query)
+}
+
+func (g *mockdb) FindPage(dataset string, query *Query) (*Page, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	matches, index, err := g.match(dataset, query)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, next := query.paginate(matches, index)
+	page := &Page{Records: []*db.Record{}, Next: next}
+	for _, recordID := range ids {
+		page.Records = append(page.Records, db.ConvertModel(recordID, g.data[recordID]))
+	}
+
+	return page, nil
+}
+
+func (g *mockdb) SearchText(dataset string, text string) ([]*db.Record, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	cache := gdbSimpleIndexCache{}
+	for name, values := range g.index {
+		cache[name] = values
+	}
+
+	var index *gdbTextIndex
+	total := 0
+	for id, model := range g.data {
+		if ds, _, _, _ := ParseID(id); ds != dataset {
+			continue
+		}
+
+		if index == nil {
+			index = newTextIndex(model.GetSchema().fullTextFields())
+		}
+		index.add(cache, func(field string) string { return dataset + "." + field }, id)
+		total++
+	}
+
+	if index == nil || len(index.Fields) == 0 {
+		return nil, fmt.Errorf("gitDB: dataset %s has no full-text fields", dataset)
+	}
+
 	result := []*db.Record{}
-	for _, searchParam := range searchParams {
-		key := dataset + "." + searchParam.Index
-
-		queryValue := strings.ToLower(searchParam.Value)
-		for recordID, value := range g.index[key] {
-			addResult := false
-			dbValue := strings.ToLower(value.(string))
-			switch searchMode {
-			case SearchEquals:
-				addResult = dbValue == queryValue
-			case SearchContains:
-				addResult = strings.Contains(dbValue, queryValue)
-			case SearchStartsWith:
-				addResult = strings.HasPrefix(dbValue, queryValue)
-			case SearchEndsWith:
-				addResult = strings.HasSuffix(dbValue, queryValue)
-			}
+	for _, recordID := range index.rank(text, total) {
+		result = append(result, db.ConvertModel(recordID, g.data[recordID]))
+	}
+
+	return result, nil
+}
 
-			if addResult {
-				result = append(result, db.ConvertModel(recordID, g.data[recordID]))
+func (g *mockdb) Count(dataset string, query *Query) (int, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	matches, _, err := g.match(dataset, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(matches), nil
+}
+
+func (g *mockdb) Aggregate(dataset string, query *Query, aggregation *Aggregation) ([]*AggregateResult, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := aggregation.validate(); err != nil {
+		return nil, err
+	}
+
+	matches, index, err := g.match(dataset, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*db.Record
+	for recordID := range matches {
+		records = append(records, db.ConvertModel(recordID, g.data[recordID]))
+	}
+
+	values := recordFieldValues(records, aggregation.fields())
+	for _, field := range aggregation.fields() {
+		if iv := index(field); iv != nil {
+			values[field] = iv
+		}
+	}
+
+	return aggregation.apply(matches, values), nil
+}
+
+func (g *mockdb) match(dataset string, query *Query) (map[string]string, func(string) gdbSimpleIndex, error) {
+	if err := query.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	index := func(index string) gdbSimpleIndex {
+		if index == "id" {
+			ids := gdbSimpleIndex{}
+			for id := range g.data {
+				if ds, _, _, _ := ParseID(id); ds == dataset {
+					ids[id] = id
+				}
 			}
+			return ids
 		}
+		return g.index[dataset+"."+index]
 	}
 
-	return result, nil
+	return query.eval(index), index, nil
 }
 
 func (g *mockdb) Delete(id string) error {
-	delete(g.data, id)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.delete(id)
 	return nil
 }
 
+func (g *mockdb) DeleteContext(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.Delete(id)
+}
+
 func (g *mockdb) DeleteOrFail(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	_, exists := g.data[id]
 	if !exists {
 		return fmt.Errorf("record %s does not exist", id)
 	}
 
+	g.delete(id)
+	return nil
+}
+
+func (g *mockdb) delete(id string) {
 	delete(g.data, id)
+	for _, index := range g.index {
+		delete(index, id)
+	}
+}
+
+func (g *mockdb) CheckIndex(dataset string, repair bool) (*IndexDrift, error) {
+	return &IndexDrift{Dataset: dataset}, nil
+}
+
+func (g *mockdb) Reindex(datasets ...string) error {
+	return nil
+}
+
+func (g *mockdb) Verify() (*VerifyReport, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	datasets := map[string]bool{}
+	for id := range g.data {
+		dataset, _, _, _ := ParseID(id)
+		datasets[dataset] = true
+	}
+
+	return &VerifyReport{Datasets: len(datasets), Records: len(g.data), Blocks: []*BlockReport{}, Problems: []*Problem{}}, nil
+}
+
+func (g *mockdb) Repair() (*RepairReport, error) {
+	return &RepairReport{Changes: []*RepairChange{}, Unresolved: []*RepairChange{}}, nil
+}
+
+func (g *mockdb) RotateKey(oldKey, newKey string) error {
+	if err := crypto.ValidKey(oldKey); err != nil {
+		return err
+	}
+	if err := crypto.ValidKey(newKey); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.config.KeyProvider == nil {
+		g.config.EncryptionKey = newKey
+	}
+	return nil
+}
+
+func (g *mockdb) RotateDatasetKey(dataset, oldKey, newKey string) error {
+	if err := crypto.ValidKey(oldKey); err != nil {
+		return err
+	}
+	if err := crypto.ValidKey(newKey); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	datasetKeys := map[string]string{dataset: newKey}
+	for name, key := range g.config.DatasetKeys {
+		if name != dataset {
+			datasetKeys[name] = key
+		}
+	}
+	g.config.DatasetKeys = datasetKeys
 	return nil
 }
 
+func (g *mockdb) CacheStats() CacheStats {
+	return CacheStats{}
+}
+
+func (g *mockdb) IndexStatus() ([]*IndexStatus, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	statuses := map[string]*IndexStatus{}
+	for id := range g.data {
+		dataset, _, _, _ := ParseID(id)
+		if _, ok := statuses[dataset]; !ok {
+			statuses[dataset] = &IndexStatus{Dataset: dataset, Fresh: true, UpdatedAt: time.Now()}
+		}
+		statuses[dataset].Records++
+	}
+
+	result := []*IndexStatus{}
+	for _, status := range statuses {
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Dataset < result[j].Dataset })
+
+	return result, nil
+}
+
 func (g *mockdb) Lock(m Model) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
 	if _, ok := m.(LockableModel); !ok {
 		return errors.New("Model is not lockable")
@@ -195,7 +511,17 @@ func (g *mockdb) Lock(m Model) error {
 	return nil
 }
 
+func (g *mockdb) LockContext(ctx context.Context, m Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.Lock(m)
+}
+
 func (g *mockdb) Unlock(m Model) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	if _, ok := m.(LockableModel); !ok {
 		return errors.New("Model is not lockable")
 	}
@@ -207,6 +533,13 @@ func (g *mockdb) Unlock(m Model) error {
 	return nil
 }
 
+func (g *mockdb) UnlockContext(ctx context.Context, m Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.Unlock(m)
+}
+
 func (g *mockdb) Upload() *Upload {
 	//todo
 	return nil
@@ -226,6 +559,9 @@ func (g *mockdb) GetLastCommitTime() (time.Time, error) {
 }
 
 func (g *mockdb) SetUser(user *User) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	g.config.User = user
 	return nil
 }
@@ -254,6 +590,9 @@ func (g *mockdb) Migrate(from Model, to Model) error {
 }
 
 func (g *mockdb) Config() Config {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
 	return g.config
 }
 
@@ -268,6 +607,10 @@ func (g *mockdb) Sync() error {
 	return nil
 }
 
+func (g *mockdb) SyncContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
 func (g *mockdb) RegisterModel(dataset string, m Model) bool {
 	return true
 }
@@ -1,8 +1,10 @@
 package gitdb_test
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/gogitdb/gitdb/v2"
@@ -42,6 +44,18 @@ func TestMockInsert(t *testing.T) {
 	}
 }
 
+func TestMockInsertUniqueIndex(t *testing.T) {
+	db := setupMock(t)
+
+	if err := db.Insert(&Customer{CustomerId: 1, Email: "alice@example.com"}); err != nil {
+		t.Errorf("db.Insert failed: %s", err)
+	}
+
+	if err := db.Insert(&Customer{CustomerId: 2, Email: "alice@example.com"}); !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("db.Insert want ErrUniqueViolation, got: %v", err)
+	}
+}
+
 func TestMockInsertMany(t *testing.T) {
 
 	db := setupMock(t)
@@ -106,6 +120,93 @@ func TestMockFetch(t *testing.T) {
 	}
 }
 
+func TestMockIterate(t *testing.T) {
+	db := setupMock(t)
+
+	dataset := "Message"
+	count := 0
+	err := db.Iterate(dataset, func(record *gitdb.Record) error {
+		count++
+		if count == 4 {
+			return gitdb.ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("db.Iterate(%s) failed: %s", dataset, err)
+	}
+
+	want := 4
+	if count != want {
+		t.Errorf("db.Iterate(%s) failed: want %d, got %d", dataset, want, count)
+	}
+}
+
+func TestMockConcurrent(t *testing.T) {
+	db := setupMock(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := db.Insert(getTestMessageWithId(200 + i)); err != nil {
+				t.Errorf("db.Insert failed: %s", err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := db.Fetch("Message"); err != nil {
+				t.Errorf("db.Fetch failed: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	want := 20
+	if count, err := db.Count("Message", gitdb.All()); err != nil || count != want {
+		t.Errorf("db.Count failed: want %d, got %d (%v)", want, count, err)
+	}
+}
+
+func TestMockContext(t *testing.T) {
+	db := setupMock(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := db.InsertContext(ctx, getTestMessageWithId(200)); err != nil {
+		t.Errorf("db.InsertContext failed: %s", err)
+	}
+
+	cancel()
+	if err := db.GetContext(ctx, gitdb.ID(getTestMessageWithId(200)), &Message{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("db.GetContext want: %s, got: %v", context.Canceled, err)
+	}
+
+	if _, err := db.SearchContext(ctx, "Message", []*gitdb.SearchParam{{Index: "From", Value: "alice@example.com"}}, gitdb.SearchEquals); !errors.Is(err, context.Canceled) {
+		t.Errorf("db.SearchContext want: %s, got: %v", context.Canceled, err)
+	}
+
+	if err := db.SyncContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("db.SyncContext want: %s, got: %v", context.Canceled, err)
+	}
+}
+
+func TestMockRotateKey(t *testing.T) {
+	db := setupMock(t)
+
+	if err := db.RotateKey("b61ba8270ccc3c1d42b4417e7bd60b71", "short"); !errors.Is(err, gitdb.ErrEncryptionKey) {
+		t.Errorf("db.RotateKey want: %s, got: %v", gitdb.ErrEncryptionKey, err)
+	}
+
+	if err := db.RotateKey("b61ba8270ccc3c1d42b4417e7bd60b71", newKey); err != nil {
+		t.Errorf("db.RotateKey failed: %s", err)
+	}
+
+	if key := db.Config().EncryptionKey; key != newKey {
+		t.Errorf("db.Config().EncryptionKey want: %s, got: %s", newKey, key)
+	}
+}
+
 func TestMockFetchBlock(t *testing.T) {
 	db := setupMock(t)
 
@@ -140,6 +241,63 @@ func TestMockSearch(t *testing.T) {
 	}
 }
 
+func TestMockFind(t *testing.T) {
+	db := setupMock(t)
+
+	query := gitdb.Where("From", gitdb.SearchEquals, "alice@example.com").And(
+		gitdb.Not(gitdb.Where("MessageId", gitdb.SearchLessThan, "105")),
+	)
+
+	results, err := db.Find("Message", query)
+	if err != nil {
+		t.Errorf("db.Find failed with error - %s", err)
+	}
+
+	want := 6
+	if len(results) != want {
+		t.Errorf("db.Find result count wrong. want: %d, got: %d", want, len(results))
+	}
+}
+
+func TestMockSearchText(t *testing.T) {
+	db := setupMock(t)
+
+	if err := db.InsertMany(getTestNotes()); err != nil {
+		t.Fatalf("db.InsertMany failed with error - %s", err)
+	}
+
+	results, err := db.SearchText("Note", "refund")
+	if err != nil {
+		t.Errorf("db.SearchText failed with error - %s", err)
+	}
+
+	if len(results) != 2 || results[0].ID() != "Note/b0/2" {
+		t.Errorf("db.SearchText want Note/b0/2 ranked first of 2 records, got: %d records", len(results))
+	}
+}
+
+func TestMockAggregate(t *testing.T) {
+	db := setupMock(t)
+
+	count, err := db.Count("Message", gitdb.Where("MessageId", gitdb.SearchGreaterThan, "108"))
+	if err != nil {
+		t.Errorf("db.Count failed with error - %s", err)
+	}
+
+	if count != 2 {
+		t.Errorf("db.Count want: %d, got: %d", 2, count)
+	}
+
+	results, err := db.Aggregate("Message", gitdb.All(), &gitdb.Aggregation{Func: gitdb.AggregateMax, Field: "MessageId"})
+	if err != nil {
+		t.Errorf("db.Aggregate failed with error - %s", err)
+	}
+
+	if len(results) != 1 || results[0].Value != 110 {
+		t.Errorf("db.Aggregate want max: %d, got: %v", 110, results)
+	}
+}
+
 func TestMockDelete(t *testing.T) {
 	db := setupMock(t)
 
@@ -1,7 +1,13 @@
 package gitdb_test
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/gogitdb/gitdb/v2"
@@ -52,6 +58,18 @@ func TestConfigValidate(t *testing.T) {
 	if err := cfg.Validate(); err == nil {
 		t.Errorf("cfg.Validate should fail if DbPath is %s", cfg.DBPath)
 	}
+
+	cfg = gitdb.NewConfig(dbPath)
+	cfg.BlockCacheBytes = -1
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("cfg.Validate should fail if BlockCacheBytes is %d", cfg.BlockCacheBytes)
+	}
+
+	cfg = gitdb.NewConfig(dbPath)
+	cfg.EncryptionKey = "short"
+	if err := cfg.Validate(); !errors.Is(err, gitdb.ErrEncryptionKey) {
+		t.Errorf("cfg.Validate want: %s, got: %v", gitdb.ErrEncryptionKey, err)
+	}
 }
 
 func TestGetLastCommitTime(t *testing.T) {
@@ -63,3 +81,345 @@ func TestGetLastCommitTime(t *testing.T) {
 		t.Errorf("dbConn.GetLastCommitTime() returned error - %s", err)
 	}
 }
+
+func TestVerify(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessage()); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	report, err := testDb.Verify()
+	if err != nil {
+		t.Fatalf("testDb.Verify failed: %s", err)
+	}
+
+	if !report.Healthy() || report.Count(gitdb.SeverityWarning) > 0 || report.Records != 3 {
+		t.Errorf("want healthy report of 3 records, got: %d records %v", report.Records, report.Problems)
+	}
+
+	if len(report.Blocks) != 1 || len(report.Blocks[0].Checksum) != 64 {
+		t.Errorf("want checksum of 1 block, got: %v", report.Blocks)
+	}
+
+	//damage the database
+	dataDir := filepath.Join(getConfig().DBPath, "data")
+	files := map[string]string{
+		"Message/b1.json":   `{"Message/b1/9": "`,
+		"Message/b2.json":   "Message/b0/8\t{}\n",
+		"Other/Lock/x.lock": "",
+	}
+	for file, data := range files {
+		path := filepath.Join(dataDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err = testDb.Verify()
+	if err != nil {
+		t.Fatalf("testDb.Verify failed: %s", err)
+	}
+
+	//unreadable b1, misplaced record in b2 and its missing index entry, orphaned lock
+	if report.Count(gitdb.SeverityError) != 2 || report.Count(gitdb.SeverityWarning) != 2 {
+		t.Errorf("want 2 errors and 2 warnings, got: %v", report.Problems)
+	}
+
+	//records do not decrypt with another key
+	testDb.Close()
+	cfg := getConfig()
+	cfg.EncryptionKey = "00000000000000000000000000000000"
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	report, err = testDb.Verify()
+	if err != nil {
+		t.Fatalf("testDb.Verify failed: %s", err)
+	}
+
+	if report.Count(gitdb.SeverityError) != 5 {
+		t.Errorf("want 5 errors, got: %v", report.Problems)
+	}
+}
+
+func TestVerifyDBReadOnly(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Note", &Note{})
+
+	n := &Note{NoteId: 1, Title: "plain title", Text: "plain text"}
+	if err := testDb.Insert(n); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+	testDb.Close()
+
+	//Note is encrypted now so a connection would seal its plain text index files when loading them
+	cfg := getConfig()
+	cfg.Factory = func(dataset string) gitdb.Model {
+		if dataset == "Note" {
+			return &secretNote{}
+		}
+		return nil
+	}
+
+	if _, err := gitdb.VerifyDB(cfg); err != nil {
+		t.Fatalf("gitdb.VerifyDB failed: %s", err)
+	}
+
+	indexFile := filepath.Join(dbPath, ".gitdb", "index", "Note", "Title.idx")
+	if data, _ := ioutil.ReadFile(indexFile); !strings.Contains(string(data), n.Title) {
+		t.Errorf("gitdb.VerifyDB changed index file %s", indexFile)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 2; i++ {
+		if err := testDb.Insert(getTestMessage()); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//change the block without making it unreadable
+	blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+	f, err := os.OpenFile(blockFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("\n")
+	f.Close()
+
+	//reading the changed block does not store its checksum
+	if err := testDb.Get("Message/b0/0", &Message{}); err != nil {
+		t.Fatalf("testDb.Get failed: %s", err)
+	}
+
+	report, err := testDb.Verify()
+	if err != nil {
+		t.Fatalf("testDb.Verify failed: %s", err)
+	}
+
+	if report.Count(gitdb.SeverityError) != 1 || !strings.Contains(report.Problems[0].Message, "block was changed outside gitdb") {
+		t.Errorf("want checksum error, got: %v", report.Problems)
+	}
+
+	//a block written by gitdb has the checksum stored
+	if err := testDb.Insert(getTestMessage()); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	if report, err = testDb.Verify(); err != nil || !report.Healthy() {
+		t.Errorf("want healthy report, got: %v (%v)", report, err)
+	}
+}
+
+func TestVerifyDB(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 2; i++ {
+		if err := testDb.Insert(getTestMessage()); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+	testDb.Close()
+
+	//remove a record behind the back of its index and leave a partially written file
+	blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+	records := readBlockFile(t, blockFile)
+	delete(records, "Message/b0/1")
+	writeBlockFile(t, blockFile, records)
+
+	tempFile := filepath.Join(dbPath, "data", "Message", ".b1.json.gdbtmp")
+	if err := ioutil.WriteFile(tempFile, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//Message is not registered so only the index files are checked
+	report, err := gitdb.VerifyDB(getConfig())
+	if err != nil {
+		t.Fatalf("gitdb.VerifyDB failed: %s", err)
+	}
+
+	messages := map[string]bool{}
+	for _, problem := range report.Problems {
+		messages[problem.Record+": "+problem.Message] = true
+	}
+
+	for _, want := range []string{
+		"Message/b0/1: index holds a record that does not exist",
+		": partially written file .b1.json.gdbtmp",
+		": index From is missing 0 records and holds 1 records that do not exist",
+		": dataset is not registered so index values were not checked",
+	} {
+		if !messages[want] {
+			t.Errorf("want problem %q, got: %v", want, report.Problems)
+		}
+	}
+
+	//the block changed behind gitdb's back is the only error
+	if report.Records != 1 || report.Count(gitdb.SeverityError) != 1 {
+		t.Errorf("want report of 1 record and 1 error, got: %d records %v", report.Records, report.Problems)
+	}
+
+	//nothing is recovered
+	if _, err := os.Stat(tempFile); err != nil {
+		t.Errorf("want partially written file kept, got: %s", err)
+	}
+
+	testDb = getDbConn(t, getConfig())
+}
+
+func TestRepair(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessage()); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	dataDir := filepath.Join(dbPath, "data")
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", dataDir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %s", args, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	if flagFakeRemote {
+		git("push", "online", "master")
+	}
+
+	//flush indexes
+	testDb.Close()
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Message", &Message{})
+
+	//damage the repository, blocks and indexes
+	blob := git("rev-parse", "HEAD:Message/b0.json")
+	files := map[string]string{
+		".git/refs/heads/master":           "not a commit\n",
+		".git/index":                       "not an index",
+		"Message/b1.json":                  `{"Message/b1/9": "`,
+		"Message/b2.json":                  "Message/b0/8\t{}\n",
+		"../.gitdb/index/Message/From.idx": "GDBI",
+	}
+	if flagFakeRemote {
+		//objects can only be fetched again from an online remote
+		files[".git/objects/"+blob[:2]+"/"+blob[2:]] = ""
+	}
+	for file, data := range files {
+		path := filepath.Join(dataDir, file)
+		os.Chmod(path, 0644)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := testDb.Repair()
+	if err != nil {
+		t.Fatalf("testDb.Repair failed: %s", err)
+	}
+
+	if len(report.Unresolved) > 0 {
+		t.Errorf("want no unresolved problems, got: %v", report.Unresolved)
+	}
+
+	//HEAD, git index, b1, b2, From.idx and the Message indexes
+	want := 6
+	if flagFakeRemote {
+		//corrupt object removed and fetched from the online remote
+		want += 2
+	}
+	if len(report.Changes) != want {
+		t.Errorf("want %d changes, got: %v", want, report.Changes)
+	}
+
+	if quarantined, _ := filepath.Glob(filepath.Join(dbPath, ".gitdb", "quarantine", "*", "Message", "*.json")); len(quarantined) != 2 {
+		t.Errorf("want 2 quarantined files, got: %v", quarantined)
+	}
+
+	verify, err := testDb.Verify()
+	if err != nil || !verify.Healthy() || verify.Count(gitdb.SeverityWarning) > 0 {
+		t.Errorf("database should be healthy after repair: %v (%v)", verify.Problems, err)
+	}
+
+	if flagFakeRemote {
+		git("fsck", "--full")
+	}
+	git("status")
+}
+
+func TestRepairUnregistered(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessage()); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+	testDb.Close()
+
+	//remove a record behind the back of its indexes and leave a partially written file
+	blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+	records := readBlockFile(t, blockFile)
+	delete(records, "Message/b0/1")
+	writeBlockFile(t, blockFile, records)
+
+	tempFile := filepath.Join(dbPath, "data", "Message", ".b1.json.gdbtmp")
+	if err := ioutil.WriteFile(tempFile, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//Message is not registered like in the gitdb command
+	testDb = getDbConn(t, getConfig())
+	report, err := testDb.Repair()
+	if err != nil {
+		t.Fatalf("testDb.Repair failed: %s", err)
+	}
+
+	if len(report.Unresolved) > 0 {
+		t.Errorf("want no unresolved problems, got: %v", report.Unresolved)
+	}
+
+	indexPath := filepath.Join(dbPath, ".gitdb", "index", "Message")
+	changes := map[string]bool{}
+	for _, change := range report.Changes {
+		changes[change.String()] = true
+	}
+
+	for _, want := range []string{
+		tempFile + ": removed partially written file",
+		filepath.Join(indexPath, "id.idx") + ": rebuilt index of 2 records",
+		filepath.Join(indexPath, "From.idx") + ": removed 1 records that do not exist",
+	} {
+		if !changes[want] {
+			t.Errorf("want change %q, got: %v", want, report.Changes)
+		}
+	}
+
+	//indexes of encrypted datasets stay sealed
+	data, err := ioutil.ReadFile(filepath.Join(indexPath, "id.idx"))
+	if err != nil || !strings.HasPrefix(string(data), "GDBE") {
+		t.Errorf("want sealed id index, got: %q (%v)", data, err)
+	}
+
+	testDb.RegisterModel("Message", &Message{})
+	drift, err := testDb.CheckIndex("Message", false)
+	if err != nil || !drift.InSync() {
+		t.Errorf("want indexes in sync after repair, got: %+v (%v)", drift, err)
+	}
+}
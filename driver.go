@@ -1,14 +1,22 @@
 package gitdb
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type dbDriver interface {
 	name() string
 	setup(db *gitdb) error
-	sync() error
+	fetch(ctx context.Context) error
+	merge(ctx context.Context) error
+	push(ctx context.Context) error
 	commit(filePath string, msg string, user *User) error
 	undo() error
-	changedFiles() []string
+	restore(filePath string) error
+	repair(report *RepairReport) error
+	changedFiles(ctx context.Context) []string
+	dirtyFiles() ([]string, error)
 	lastCommitTime() (time.Time, error)
 }
 
@@ -17,6 +25,4 @@ type gitDBDriver interface {
 	init() error
 	clone() error
 	addRemote() error
-	pull() error
-	push() error
 }
@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -122,8 +123,16 @@ func (d *gitDriver) addRemote() error {
 	return nil
 }
 
-func (d *gitDriver) sync() error {
-	return d.driver.sync()
+func (d *gitDriver) fetch(ctx context.Context) error {
+	return d.driver.fetch(ctx)
+}
+
+func (d *gitDriver) merge(ctx context.Context) error {
+	return d.driver.merge(ctx)
+}
+
+func (d *gitDriver) push(ctx context.Context) error {
+	return d.driver.push(ctx)
 }
 
 func (d *gitDriver) commit(filePath string, msg string, user *User) error {
@@ -141,8 +150,22 @@ func (d *gitDriver) undo() error {
 	return d.driver.undo()
 }
 
-func (d *gitDriver) changedFiles() []string {
-	return d.driver.changedFiles()
+func (d *gitDriver) restore(filePath string) error {
+	return d.driver.restore(filePath)
+}
+
+func (d *gitDriver) repair(report *RepairReport) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return d.driver.repair(report)
+}
+
+func (d *gitDriver) changedFiles(ctx context.Context) []string {
+	return d.driver.changedFiles(ctx)
+}
+
+func (d *gitDriver) dirtyFiles() ([]string, error) {
+	return d.driver.dirtyFiles()
 }
 
 func (d *gitDriver) lastCommitTime() (time.Time, error) {
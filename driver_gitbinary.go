@@ -1,8 +1,13 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -77,31 +82,30 @@ func (d *gitBinaryDriver) addRemote() error {
 	return nil
 }
 
-func (d *gitBinaryDriver) sync() error {
-	if err := d.pull(); err != nil {
-		return err
-	}
-	if err := d.push(); err != nil {
-		return err
+//fetch downloads the changes of the online remote without changing the working tree
+func (d *gitBinaryDriver) fetch(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "git", "-C", d.absDBPath, "fetch", "online", "master")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Error(string(out))
+		return errors.New("failed to fetch data from online remote")
 	}
 
 	return nil
 }
 
-func (d *gitBinaryDriver) pull() error {
-	cmd := exec.Command("git", "-C", d.absDBPath, "pull", "online", "master")
-	// log(utils.CmdToString(cmd))
+//merge merges the changes fetched from the online remote into the working tree
+func (d *gitBinaryDriver) merge(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "git", "-C", d.absDBPath, "merge", "--no-edit", "online/master")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		log.Error(string(out))
-
-		return errors.New("failed to pull data from online remote")
+		return errors.New("failed to merge data from online remote")
 	}
 
 	return nil
 }
 
-func (d *gitBinaryDriver) push() error {
-	cmd := exec.Command("git", "-C", d.absDBPath, "push", "online", "master")
+func (d *gitBinaryDriver) push(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "git", "-C", d.absDBPath, "push", "online", "master")
 	// log(utils.CmdToString(cmd))
 	if out, err := cmd.CombinedOutput(); err != nil {
 		log.Error(string(out))
@@ -163,19 +167,24 @@ func (d *gitBinaryDriver) undo() error {
 	return nil
 }
 
-func (d *gitBinaryDriver) changedFiles() []string {
+func (d *gitBinaryDriver) restore(filePath string) error {
+	cmd := exec.Command("git", "-C", d.absDBPath, "checkout", "HEAD", "--", filePath)
+	// log(utils.CmdToString(cmd))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Error(string(out))
+		return err
+	}
+
+	log.Info("restored " + filePath)
+	return nil
+}
+
+func (d *gitBinaryDriver) changedFiles(ctx context.Context) []string {
 	var files []string
 	if len(d.config.OnlineRemote) > 0 {
 		log.Test("getting list of changed files...")
-		// git fetch
-		cmd := exec.Command("git", "-C", d.absDBPath, "fetch", "online", "master")
-		if out, err := cmd.CombinedOutput(); err != nil {
-			log.Error(string(out))
-			return files
-		}
-
 		// git diff --name-only ..online/master
-		cmd = exec.Command("git", "-C", d.absDBPath, "diff", "--name-only", "..online/master")
+		cmd := exec.CommandContext(ctx, "git", "-C", d.absDBPath, "diff", "--name-only", "..online/master")
 		out, err := cmd.CombinedOutput()
 		if err != nil {
 			log.Error(string(out))
@@ -198,6 +207,31 @@ func (d *gitBinaryDriver) changedFiles() []string {
 	return files
 }
 
+//dirtyFiles returns the files of the repository that differ from HEAD or are untracked
+func (d *gitBinaryDriver) dirtyFiles() ([]string, error) {
+	out, err := d.git("status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, errors.New("git status failed: " + strings.TrimSpace(string(out)))
+	}
+
+	var files []string
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+
+		files = append(files, entry[3:])
+		//renames and copies are followed by the path they came from
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+
+	return files, nil
+}
+
 func (d *gitBinaryDriver) lastCommitTime() (time.Time, error) {
 	var t time.Time
 	cmd := exec.Command("git", "-C", d.absDBPath, "log", "-1", "--remotes=online", "--format=%cd", "--date=iso")
@@ -215,3 +249,202 @@ func (d *gitBinaryDriver) lastCommitTime() (time.Time, error) {
 
 	return t, errors.New("no commit history in repo")
 }
+
+func (d *gitBinaryDriver) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", d.absDBPath}, args...)...)
+	// log(utils.CmdToString(cmd))
+	return cmd.CombinedOutput()
+}
+
+func (d *gitBinaryDriver) repair(report *RepairReport) error {
+	if err := d.repairHead(report); err != nil {
+		return err
+	}
+
+	if err := d.repairIndex(report); err != nil {
+		return err
+	}
+
+	return d.repairObjects(report)
+}
+
+//repairHead points a branch whose ref is corrupt at its last valid commit
+//found in the reflog or else at the branch of the online remote
+func (d *gitBinaryDriver) repairHead(report *RepairReport) error {
+	if _, err := d.git("rev-parse", "--verify", "--quiet", "HEAD^{commit}"); err == nil {
+		return nil
+	}
+
+	ref := "refs/heads/master"
+	if out, err := d.git("symbolic-ref", "HEAD"); err == nil {
+		ref = strings.TrimSpace(string(out))
+	} else if err := writeFileAtomic(filepath.Join(d.absDBPath, ".git", "HEAD"), []byte("ref: "+ref+"\n"), 0644); err != nil {
+		return err
+	}
+
+	commit := d.lastValidCommit(ref)
+	if len(commit) == 0 && len(d.config.OnlineRemote) > 0 {
+		if out, err := d.git("fetch", "online", "master"); err != nil {
+			log.Error(string(out))
+		} else if out, err := d.git("rev-parse", "--verify", "--quiet", "online/master^{commit}"); err == nil {
+			commit = strings.TrimSpace(string(out))
+		}
+	}
+
+	if len(commit) == 0 {
+		report.unresolved(filepath.Join(d.absDBPath, ".git", "HEAD"), "HEAD is corrupt and no valid commit was found to reset it to")
+		return nil
+	}
+
+	if err := writeFileAtomic(filepath.Join(d.absDBPath, ".git", filepath.FromSlash(ref)), []byte(commit+"\n"), 0644); err != nil {
+		return err
+	}
+
+	// remove reflog entries pointing at missing commits
+	if out, err := d.git("reflog", "expire", "--stale-fix", "--all"); err != nil {
+		log.Error(string(out))
+	}
+
+	report.changed(filepath.Join(d.absDBPath, ".git", filepath.FromSlash(ref)), "reset corrupt HEAD to commit %s", commit)
+	return nil
+}
+
+//lastValidCommit returns the last commit in the reflog of ref that exists
+func (d *gitBinaryDriver) lastValidCommit(ref string) string {
+	for _, logFile := range []string{filepath.FromSlash(ref), "HEAD"} {
+		b, err := ioutil.ReadFile(filepath.Join(d.absDBPath, ".git", "logs", logFile))
+		if err != nil {
+			continue
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		for i := len(lines) - 1; i >= 0; i-- {
+			fields := strings.Fields(lines[i])
+			if len(fields) < 2 {
+				continue
+			}
+
+			if _, err := d.git("cat-file", "-e", fields[1]+"^{commit}"); err == nil {
+				return fields[1]
+			}
+		}
+	}
+
+	return ""
+}
+
+//repairIndex rebuilds a corrupt git index from HEAD
+func (d *gitBinaryDriver) repairIndex(report *RepairReport) error {
+	out, err := d.git("ls-files")
+	if err == nil || !strings.Contains(string(out), "index file") {
+		return nil
+	}
+
+	if err := os.Remove(filepath.Join(d.absDBPath, ".git", "index")); err != nil {
+		return err
+	}
+
+	if out, err := d.git("reset"); err != nil {
+		log.Error(string(out))
+		report.unresolved(filepath.Join(d.absDBPath, ".git", "index"), "could not rebuild git index: %s", strings.TrimSpace(string(out)))
+		return nil
+	}
+
+	report.changed(filepath.Join(d.absDBPath, ".git", "index"), "rebuilt corrupt git index from HEAD")
+	return nil
+}
+
+var corruptObjectRegex = regexp.MustCompile(`object file (\S+) is (empty|corrupt)`)
+
+//repairObjects removes corrupt objects and fetches missing objects again from the online remote
+func (d *gitBinaryDriver) repairObjects(report *RepairReport) error {
+	missing, err := d.fsck(report)
+	if err != nil || missing == 0 {
+		return err
+	}
+
+	if len(d.config.OnlineRemote) == 0 {
+		report.unresolved(filepath.Join(d.absDBPath, ".git", "objects"), "%d objects are missing and there is no online remote to fetch them from", missing)
+		return nil
+	}
+
+	// a fetch skips objects git believes it has so unpack every object of a fresh clone
+	tmp, err := ioutil.TempDir("", "gitdb-repair")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if out, err := exec.Command("git", "clone", "--bare", "--no-local", d.config.OnlineRemote, tmp).CombinedOutput(); err != nil {
+		report.unresolved(filepath.Join(d.absDBPath, ".git", "objects"), "%d objects are missing and the online remote could not be cloned: %s", missing, strings.TrimSpace(string(out)))
+		return nil
+	}
+
+	packs, _ := filepath.Glob(filepath.Join(tmp, "objects", "pack", "*.pack"))
+	for _, pack := range packs {
+		if err := d.unpack(pack); err != nil {
+			return err
+		}
+	}
+
+	stillMissing, err := d.fsck(report)
+	if err != nil {
+		return err
+	}
+
+	if stillMissing > 0 {
+		report.unresolved(filepath.Join(d.absDBPath, ".git", "objects"), "%d objects are missing from the online remote too", stillMissing)
+	}
+
+	if fetched := missing - stillMissing; fetched > 0 {
+		report.changed(filepath.Join(d.absDBPath, ".git", "objects"), "fetched %d missing objects from the online remote", fetched)
+	}
+
+	return nil
+}
+
+//fsck removes corrupt object files and returns the number of missing objects
+func (d *gitBinaryDriver) fsck(report *RepairReport) (int, error) {
+	for {
+		out, _ := d.git("fsck", "--full")
+		corrupt := corruptObjectRegex.FindAllStringSubmatch(string(out), -1)
+		if len(corrupt) == 0 {
+			missing := 0
+			for _, line := range strings.Split(string(out), "\n") {
+				if strings.HasPrefix(line, "missing ") {
+					missing++
+				}
+			}
+			return missing, nil
+		}
+
+		for _, match := range corrupt {
+			objectFile := match[1]
+			if !filepath.IsAbs(objectFile) {
+				objectFile = filepath.Join(d.absDBPath, objectFile)
+			}
+
+			if err := os.Remove(objectFile); err != nil {
+				return 0, err
+			}
+			report.changed(objectFile, "removed %s object file", match[2])
+		}
+	}
+}
+
+func (d *gitBinaryDriver) unpack(pack string) error {
+	f, err := os.Open(pack)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cmd := exec.Command("git", "-C", d.absDBPath, "unpack-objects", "-q")
+	cmd.Stdin = f
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Error(string(out))
+		return err
+	}
+
+	return nil
+}
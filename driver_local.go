@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
 	"os"
 	"time"
@@ -27,7 +28,15 @@ func (d *localDriver) setup(db *gitdb) error {
 	return nil
 }
 
-func (d *localDriver) sync() error {
+func (d *localDriver) fetch(ctx context.Context) error {
+	return nil
+}
+
+func (d *localDriver) merge(ctx context.Context) error {
+	return nil
+}
+
+func (d *localDriver) push(ctx context.Context) error {
 	return nil
 }
 
@@ -41,11 +50,23 @@ func (d *localDriver) undo() error {
 	return nil
 }
 
-func (d *localDriver) changedFiles() []string {
+func (d *localDriver) restore(filePath string) error {
+	return errors.New("no commit history in repo")
+}
+
+func (d *localDriver) repair(report *RepairReport) error {
+	return nil
+}
+
+func (d *localDriver) changedFiles(ctx context.Context) []string {
 	var files []string
 	return files
 }
 
+func (d *localDriver) dirtyFiles() ([]string, error) {
+	return nil, errors.New("no commit history in repo")
+}
+
 func (d *localDriver) lastCommitTime() (time.Time, error) {
 	return time.Now(), errors.New("no commit history in repo")
 }
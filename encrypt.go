@@ -0,0 +1,113 @@
+package gitdb
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//encryptTag marks a field of a model as encrypted e.g `gitdb:"encrypt"`
+const encryptTag = "encrypt"
+
+//keysFor returns the keys records of dataset are encrypted with
+func (g *gitdb) keysFor(dataset string) *crypto.KeyRing {
+	if keys, ok := g.datasetKeys[dataset]; ok {
+		return keys
+	}
+
+	return g.keys
+}
+
+//keysForFile returns the keys of the dataset a block or index file belongs to
+func (g *gitdb) keysForFile(path string) *crypto.KeyRing {
+	return g.keysFor(filepath.Base(filepath.Dir(path)))
+}
+
+//sealsIndexes reports whether the index files of dataset are sealed
+//i.e its records or some of their fields are encrypted
+func (g *gitdb) sealsIndexes(dataset string) bool {
+	m := g.modelFor(dataset)
+	return m != nil && (m.ShouldEncrypt() || len(encryptedFields(m)) > 0)
+}
+
+//encryptRecord encrypts the record of m if m should be encrypted
+//or else the fields of the record marked as encrypted
+func (g *gitdb) encryptRecord(m Model, record string) (string, error) {
+	keys := g.keysFor(m.GetSchema().name())
+	if m.ShouldEncrypt() {
+		return keys.Encrypt(record)
+	}
+
+	return db.EncryptFields(record, encryptedFields(m), keys)
+}
+
+//encryptedFields returns the JSON names of the fields of m marked as
+//encrypted by its schema or by struct tags sorted by name
+func encryptedFields(m Model) []string {
+	names := make(map[string]bool)
+	for name := range m.GetSchema().encrypted {
+		names[name] = true
+	}
+	if w, ok := m.(*model); ok {
+		m = w.Data
+	}
+	taggedFields(reflect.TypeOf(m), names)
+
+	fields := make([]string, 0, len(names))
+	for name := range names {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+//taggedFields adds the JSON names of the fields of t tagged with encryptTag to names
+func taggedFields(t reflect.Type, names map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		if name == "-" {
+			continue
+		}
+
+		//fields of embedded structs are fields of the JSON object of t
+		if f.Anonymous && len(name) == 0 {
+			taggedFields(f.Type, names)
+			continue
+		}
+
+		if f.PkgPath != "" || !hasTag(f.Tag.Get("gitdb"), encryptTag) {
+			continue
+		}
+
+		if len(name) == 0 {
+			name = f.Name
+		}
+		names[name] = true
+	}
+}
+
+//jsonName returns the name of f in its json struct tag
+func jsonName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("json"), ",")[0]
+}
+
+func hasTag(tag, value string) bool {
+	for _, v := range strings.Split(tag, ",") {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,481 @@
+package gitdb_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+func TestEncryptedRecordsAreAuthenticated(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	m := getTestMessageWithId(1)
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+	records := readBlockFile(t, blockFile)
+	stored := records[gitdb.ID(m)]
+	if !strings.HasPrefix(stored, "gdb:") || strings.Contains(stored, m.From) {
+		t.Fatalf("record is not stored in an envelope: %s", stored)
+	}
+
+	//flip a byte of the sealed message
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(stored, "gdb:"))
+	if err != nil {
+		t.Fatalf("envelope is not base64: %s", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	records[gitdb.ID(m)] = "gdb:" + base64.RawURLEncoding.EncodeToString(sealed)
+	writeBlockFile(t, blockFile, records)
+
+	if err := testDb.Get(gitdb.ID(m), &Message{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+}
+
+func TestEncryptedRecordsWithWrongKey(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	m := getTestMessageWithId(1)
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	testDb.Close()
+	cfg := getConfig()
+	cfg.EncryptionKey = "00000000000000000000000000000000"
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Get(gitdb.ID(m), &Message{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+
+	testDb.Close()
+	cfg.EncryptionKey = ""
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Get(gitdb.ID(m), &Message{}); !errors.Is(err, gitdb.ErrNoEncryptionKey) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrNoEncryptionKey, err)
+	}
+
+	if err := testDb.Insert(getTestMessageWithId(2)); !errors.Is(err, gitdb.ErrNoEncryptionKey) {
+		t.Errorf("testDb.Insert want: %s, got: %v", gitdb.ErrNoEncryptionKey, err)
+	}
+}
+
+func TestLegacyEncryptedRecords(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	want := getTestMessageWithId(1)
+	if err := testDb.Insert(want); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//replace the record with one encrypted by earlier versions of gitdb
+	blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+	record, err := testDb.Fetch("Message")
+	if err != nil || len(record) != 1 {
+		t.Fatalf("testDb.Fetch failed: %v", err)
+	}
+	records := readBlockFile(t, blockFile)
+	records[gitdb.ID(want)] = encryptCFB(t, getConfig().EncryptionKey, record[0].Data())
+	records["Message/b0/2"] = encryptCFB(t, "00000000000000000000000000000000", record[0].Data())
+	writeBlockFile(t, blockFile, records)
+
+	got := &Message{}
+	if err := testDb.Get(gitdb.ID(want), got); err != nil {
+		t.Fatalf("testDb.Get failed: %s", err)
+	}
+
+	if got.From != want.From || got.Body != want.Body {
+		t.Errorf("testDb.Get want: %v, got: %v", want, got)
+	}
+
+	if err := testDb.Get("Message/b0/2", &Message{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+}
+
+//encryptCFB encrypts message the way earlier versions of gitdb did
+func encryptCFB(t *testing.T, key string, message string) string {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipherText := make([]byte, aes.BlockSize+len(message))
+	cipher.NewCFBEncrypter(block, cipherText[:aes.BlockSize]).XORKeyStream(cipherText[aes.BlockSize:], []byte(message))
+	return base64.URLEncoding.EncodeToString(cipherText)
+}
+
+func readBlockFile(t *testing.T, blockFile string) map[string]string {
+	data, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+
+	records := map[string]string{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatalf("block is not JSON: %s", err)
+	}
+	return records
+}
+
+//writeBlockFile writes records to blockFile with a new modification time so it is not read from the block cache
+func writeBlockFile(t *testing.T, blockFile string, records map[string]string) {
+	data, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(blockFile, data, 0744); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(blockFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type Account struct {
+	gitdb.TimeStampedModel
+	AccountID int
+	Name      string
+	IDNumber  string `gitdb:"encrypt"`
+	Card      string `json:"card"`
+}
+
+func (c *Account) GetSchema() *gitdb.Schema {
+	indexes := map[string]interface{}{"Name": c.Name}
+	return gitdb.NewSchema("Account", "b0", strconv.Itoa(c.AccountID), indexes, gitdb.EncryptFields("card"))
+}
+
+func (c *Account) Validate() error     { return nil }
+func (c *Account) ShouldEncrypt() bool { return false }
+
+func getTestAccount(id int) *Account {
+	return &Account{AccountID: id, Name: "Jane Doe", IDNumber: "AB123456C", Card: "4111111111111111"}
+}
+
+func TestEncryptedFields(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+
+	c := getTestAccount(1)
+	if err := testDb.Insert(c); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	stored := readBlockFile(t, filepath.Join(dbPath, "data", "Account", "b0.json"))[gitdb.ID(c)]
+	if !strings.Contains(stored, `"Name":"Jane Doe"`) || !strings.Contains(stored, `"Encrypted":["IDNumber","card"]`) {
+		t.Errorf("fields that are not encrypted should be readable: %s", stored)
+	}
+	if strings.Contains(stored, c.IDNumber) || strings.Contains(stored, c.Card) {
+		t.Errorf("encrypted fields are readable: %s", stored)
+	}
+
+	got := &Account{}
+	if err := testDb.Get(gitdb.ID(c), got); err != nil {
+		t.Fatalf("testDb.Get failed: %s", err)
+	}
+	if got.IDNumber != c.IDNumber || got.Card != c.Card || got.Name != c.Name {
+		t.Errorf("testDb.Get want: %+v, got: %+v", c, got)
+	}
+
+	records, err := testDb.Search("Account", []*gitdb.SearchParam{{Index: "Name", Value: c.Name}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("testDb.Search want: 1 record, got: %d (%v)", len(records), err)
+	}
+	if err := records[0].Hydrate(got); err != nil || got.Card != c.Card {
+		t.Errorf("record.Hydrate want: %s, got: %s (%v)", c.Card, got.Card, err)
+	}
+
+	//encrypted fields need the key
+	testDb.Close()
+	cfg := getConfig()
+	cfg.EncryptionKey = ""
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Account", &Account{})
+	if err := testDb.Get(gitdb.ID(c), &Account{}); !errors.Is(err, gitdb.ErrNoEncryptionKey) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrNoEncryptionKey, err)
+	}
+
+	testDb.Close()
+	cfg.EncryptionKey = newKey
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Account", &Account{})
+	if err := testDb.Get(gitdb.ID(c), &Account{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+}
+
+func TestRotateKeyWithEncryptedFields(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+
+	c := getTestAccount(1)
+	if err := testDb.Insert(c); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	if err := testDb.RotateKey(getConfig().EncryptionKey, newKey); err != nil {
+		t.Fatalf("testDb.RotateKey failed: %s", err)
+	}
+
+	testDb.Close()
+	cfg := getConfig()
+	cfg.EncryptionKey = newKey
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Account", &Account{})
+
+	got := &Account{}
+	if err := testDb.Get(gitdb.ID(c), got); err != nil || got.Card != c.Card {
+		t.Errorf("testDb.Get want: %s, got: %s (%v)", c.Card, got.Card, err)
+	}
+}
+
+func TestDatasetKeys(t *testing.T) {
+	cfg := getConfig()
+	cfg.DatasetKeys = map[string]string{"Account": newKey}
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+
+	c := getTestAccount(1)
+	m := getTestMessageWithId(1)
+	if err := testDb.InsertMany([]gitdb.Model{c, m}); err != nil {
+		t.Fatalf("testDb.InsertMany failed: %s", err)
+	}
+
+	got := &Account{}
+	if err := testDb.Get(gitdb.ID(c), got); err != nil || got.Card != c.Card {
+		t.Errorf("testDb.Get want: %s, got: %s (%v)", c.Card, got.Card, err)
+	}
+
+	//rotating the encryption key leaves datasets with their own key as they are
+	blockFile := filepath.Join(dbPath, "data", "Account", "b0.json")
+	before := readBlockFile(t, blockFile)
+	if err := testDb.RotateKey(cfg.EncryptionKey, "fedcba9876543210fedcba9876543210"); err != nil {
+		t.Fatalf("testDb.RotateKey failed: %s", err)
+	}
+	if after := readBlockFile(t, blockFile); after[gitdb.ID(c)] != before[gitdb.ID(c)] {
+		t.Error("testDb.RotateKey re-encrypted a dataset with its own key")
+	}
+
+	//the dataset key is needed to read the dataset
+	testDb.Close()
+	cfg = getConfig()
+	cfg.EncryptionKey = "fedcba9876543210fedcba9876543210"
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+	testDb.RegisterModel("Account", &Account{})
+
+	if err := testDb.Get(gitdb.ID(m), &Message{}); err != nil {
+		t.Errorf("testDb.Get failed: %s", err)
+	}
+	if err := testDb.Get(gitdb.ID(c), &Account{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+
+	cfg.DatasetKeys = map[string]string{"Account": "short"}
+	if err := cfg.Validate(); !errors.Is(err, gitdb.ErrEncryptionKey) {
+		t.Errorf("cfg.Validate want: %s, got: %v", gitdb.ErrEncryptionKey, err)
+	}
+}
+
+func TestDatasetKeysAreIsolated(t *testing.T) {
+	cfg := getConfig()
+	cfg.DatasetKeys = map[string]string{"Message": newKey}
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	m := getTestMessageWithId(1)
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//the key of another dataset does not decrypt Message
+	testDb.Close()
+	cfg = getConfig()
+	cfg.DatasetKeys = map[string]string{"Account": newKey}
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Get(gitdb.ID(m), &Message{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+}
+
+func TestRotateDatasetKey(t *testing.T) {
+	cfg := getConfig()
+	cfg.DatasetKeys = map[string]string{"Account": newKey}
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+
+	c := getTestAccount(1)
+	m := getTestMessageWithId(1)
+	if err := testDb.InsertMany([]gitdb.Model{c, m}); err != nil {
+		t.Fatalf("testDb.InsertMany failed: %s", err)
+	}
+
+	accountBlock := filepath.Join(dbPath, "data", "Account", "b0.json")
+	messageBlock := filepath.Join(dbPath, "data", "Message", "b0.json")
+	accounts, messages := readBlockFile(t, accountBlock), readBlockFile(t, messageBlock)
+
+	rotatedKey := "fedcba9876543210fedcba9876543210"
+	if err := testDb.RotateDatasetKey("Account", newKey, rotatedKey); err != nil {
+		t.Fatalf("testDb.RotateDatasetKey failed: %s", err)
+	}
+
+	if after := readBlockFile(t, accountBlock); after[gitdb.ID(c)] == accounts[gitdb.ID(c)] {
+		t.Error("testDb.RotateDatasetKey did not re-encrypt the dataset")
+	}
+	if after := readBlockFile(t, messageBlock); after[gitdb.ID(m)] != messages[gitdb.ID(m)] {
+		t.Error("testDb.RotateDatasetKey re-encrypted another dataset")
+	}
+
+	if key := testDb.Config().DatasetKeys["Account"]; key != rotatedKey {
+		t.Errorf("Config.DatasetKeys want: %s, got: %s", rotatedKey, key)
+	}
+
+	//the dataset and its sealed indexes are read with the new key
+	testDb.Close()
+	cfg = getConfig()
+	cfg.DatasetKeys = map[string]string{"Account": rotatedKey}
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+	testDb.RegisterModel("Account", &Account{})
+
+	got := &Account{}
+	if err := testDb.Get(gitdb.ID(c), got); err != nil || got.Card != c.Card {
+		t.Errorf("testDb.Get want: %s, got: %s (%v)", c.Card, got.Card, err)
+	}
+
+	records, err := testDb.Search("Account", []*gitdb.SearchParam{{Index: "Name", Value: c.Name}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Search want: %d records, got: %d (%v)", 1, len(records), err)
+	}
+
+	if err := testDb.RotateDatasetKey("Unknown", newKey, rotatedKey); !errors.Is(err, gitdb.ErrInvalidDataset) {
+		t.Errorf("testDb.RotateDatasetKey want: %s, got: %v", gitdb.ErrInvalidDataset, err)
+	}
+}
+
+func TestSealedIndexes(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+	testDb.RegisterModel("Note", &Note{})
+
+	m := getTestMessageWithId(1)
+	a := getTestAccount(1)
+	n := &Note{NoteId: 1, Title: "plain title", Text: "plain text"}
+	if err := testDb.InsertMany([]gitdb.Model{m, a, n}); err != nil {
+		t.Fatalf("testDb.InsertMany failed: %s", err)
+	}
+	testDb.Close()
+
+	indexPath := filepath.Join(dbPath, ".gitdb", "index")
+	sealed := map[string]string{
+		filepath.Join(indexPath, "Message", "From.idx"): m.From,
+		filepath.Join(indexPath, "Account", "Name.idx"): a.Name,
+	}
+	for indexFile, value := range sealed {
+		data, err := ioutil.ReadFile(indexFile)
+		if err != nil {
+			t.Fatalf("ioutil.ReadFile failed: %s", err)
+		}
+		if !strings.HasPrefix(string(data), "GDBE") || strings.Contains(string(data), value) {
+			t.Errorf("index file %s is not sealed", indexFile)
+		}
+	}
+
+	//indexes of datasets that are not encrypted are not sealed
+	if data, _ := ioutil.ReadFile(filepath.Join(indexPath, "Note", "Title.idx")); !strings.Contains(string(data), n.Title) {
+		t.Error("index file of Note should not be sealed")
+	}
+
+	//equality search works on the sealed indexes
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Message", &Message{})
+	testDb.RegisterModel("Account", &Account{})
+	assertSearch(t, "Message", "From", m.From)
+	assertSearch(t, "Account", "Name", a.Name)
+
+	//sealed indexes are sealed with the new key when the key is rotated
+	before, _ := ioutil.ReadFile(filepath.Join(indexPath, "Message", "From.idx"))
+	if err := testDb.RotateKey(getConfig().EncryptionKey, newKey); err != nil {
+		t.Fatalf("testDb.RotateKey failed: %s", err)
+	}
+	testDb.Close()
+
+	if after, _ := ioutil.ReadFile(filepath.Join(indexPath, "Message", "From.idx")); string(after) == string(before) {
+		t.Error("testDb.RotateKey did not seal the index with the new key")
+	}
+
+	cfg := getConfig()
+	cfg.EncryptionKey = newKey
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+	testDb.RegisterModel("Account", &Account{})
+	assertSearch(t, "Message", "From", m.From)
+	assertSearch(t, "Account", "Name", a.Name)
+
+	if drift, err := testDb.CheckIndex("Account", false); err != nil || !drift.InSync() {
+		t.Errorf("testDb.CheckIndex want: in sync, got: %+v (%v)", drift, err)
+	}
+
+	//plain text index files written before a dataset was encrypted are sealed when they are loaded
+	testDb.RegisterModel("Note", &secretNote{})
+	assertSearch(t, "Note", "Title", n.Title)
+	if records, err := testDb.SearchText("Note", "plain"); err != nil || len(records) != 1 {
+		t.Errorf("testDb.SearchText want: 1 record, got: %d (%v)", len(records), err)
+	}
+
+	for _, indexFile := range []string{filepath.Join(indexPath, "Note", "Title.idx"), filepath.Join(indexPath, "Note", "text.fts")} {
+		data, err := ioutil.ReadFile(indexFile)
+		if err != nil {
+			t.Fatalf("ioutil.ReadFile failed: %s", err)
+		}
+		if !strings.HasPrefix(string(data), "GDBE") || strings.Contains(string(data), "plain") {
+			t.Errorf("plain text index file %s was not sealed", indexFile)
+		}
+	}
+}
+
+//secretNote is a Note whose records are encrypted
+type secretNote struct {
+	Note
+}
+
+func (n *secretNote) ShouldEncrypt() bool { return true }
+
+func assertSearch(t *testing.T, dataset, index, value string) {
+	t.Helper()
+	records, err := testDb.Search(dataset, []*gitdb.SearchParam{{Index: index, Value: value}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Search %s want: 1 record, got: %d (%v)", dataset, len(records), err)
+	}
+}
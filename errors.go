@@ -1,6 +1,10 @@
 package gitdb
 
-import "github.com/gogitdb/gitdb/v2/internal/errors"
+import (
+	"fmt"
+
+	"github.com/gogitdb/gitdb/v2/internal/errors"
+)
 
 var (
 	ErrNoRecords       = errors.ErrNoRecords
@@ -11,6 +15,16 @@ var (
 	ErrNoOnlineRemote  = errors.ErrNoOnlineRemote
 	ErrAccessDenied    = errors.ErrAccessDenied
 	ErrInvalidDataset  = errors.ErrInvalidDataset
+	ErrUniqueViolation = errors.ErrUniqueViolation
+	ErrIndexBuilding   = errors.ErrIndexBuilding
+	//ErrStopIteration can be returned by the func passed to Iterate to stop without an error
+	ErrStopIteration = errors.ErrStopIteration
+	//ErrNoEncryptionKey is returned when reading or writing encrypted records without Config.EncryptionKey
+	ErrNoEncryptionKey = errors.ErrNoEncryptionKey
+	//ErrEncryptionKey is returned when Config.EncryptionKey is not a valid AES key
+	ErrEncryptionKey = errors.ErrEncryptionKey
+	//ErrDecryptionFailed is returned when an encrypted record was tampered with or encrypted with another key
+	ErrDecryptionFailed = errors.ErrDecryptionFailed
 )
 
 type ResolvableError interface {
@@ -33,3 +47,19 @@ func (e Error) Resolution() string {
 func ErrorWithResolution(e error, resolution string) Error {
 	return Error{err: e, resolution: resolution}
 }
+
+//UniqueViolationError is returned by Insert when a record holds the same
+//value for a unique index as another record. It wraps ErrUniqueViolation
+type UniqueViolationError struct {
+	Index    string
+	Value    interface{}
+	RecordID string
+}
+
+func (e *UniqueViolationError) Error() string {
+	return fmt.Sprintf("%s: %s %v is already used by %s", ErrUniqueViolation, e.Index, e.Value, e.RecordID)
+}
+
+func (e *UniqueViolationError) Unwrap() error {
+	return ErrUniqueViolation
+}
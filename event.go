@@ -20,10 +20,12 @@ type dbEvent struct {
 	Dataset     string
 	Description string
 	Commit      bool
+	//done is closed once the event loop has handled a write or delete event
+	done chan struct{}
 }
 
 func newWriteEvent(description string, dataset string, commit bool) *dbEvent {
-	return &dbEvent{Type: w, Description: description, Dataset: dataset, Commit: commit}
+	return &dbEvent{Type: w, Description: description, Dataset: dataset, Commit: commit, done: make(chan struct{})}
 }
 
 func newWriteBeforeEvent(description string, dataset string) *dbEvent {
@@ -35,7 +37,7 @@ func newReadEvent(description string, dataset string) *dbEvent {
 }
 
 func newDeleteEvent(description string, dataset string, commit bool) *dbEvent {
-	return &dbEvent{Type: w, Description: description, Dataset: dataset, Commit: commit}
+	return &dbEvent{Type: w, Description: description, Dataset: dataset, Commit: commit, done: make(chan struct{})}
 }
 
 func (g *gitdb) startEventLoop() {
@@ -57,6 +59,7 @@ func (g *gitdb) startEventLoop() {
 						}
 						log.Test("handled write event for " + e.Description)
 					}
+					close(e.done)
 					g.commit.Done()
 				default:
 					log.Test("No handler found for " + string(e.Type) + " event")
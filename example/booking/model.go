@@ -58,8 +58,10 @@ func (b *BookingModel) GetSchema() *gitdb.Schema {
 	indexes["Guests"] = b.Guests
 	indexes["CustomerId"] = b.CustomerId
 	indexes["CreationDate"] = b.CreatedAt.Format("2006-01-02")
+	indexes["Purpose"] = b.Purpose
 
-	return gitdb.NewSchema(name, block, record, indexes)
+	//Full-text indexes can be searched with SearchText
+	return gitdb.NewSchema(name, block, record, indexes, gitdb.FullText("Purpose"))
 }
 
 //GetLockFileNames example
@@ -0,0 +1,308 @@
+package gitdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//gdbTextIndex is an inverted index of the full-text fields of a dataset
+type gdbTextIndex struct {
+	//Fields are the full-text fields the index was built from
+	Fields []string
+	//Tokens maps a token to the records it occurs in and how often (token => (recordID => count))
+	Tokens map[string]map[string]int
+}
+
+type gdbTextIndexCache map[string]*gdbTextIndex //indexFile => text index
+
+func newTextIndex(fields []string) *gdbTextIndex {
+	return &gdbTextIndex{Fields: fields, Tokens: make(map[string]map[string]int)}
+}
+
+//tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+//textValue returns the text of an index value
+func textValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return indexValueString(v)
+	}
+}
+
+//add indexes the tokens of the full-text fields of recordID found in cache
+func (t *gdbTextIndex) add(cache gdbSimpleIndexCache, indexFile func(field string) string, recordID string) {
+	for _, field := range t.Fields {
+		for _, token := range tokenize(textValue(cache[indexFile(field)][recordID])) {
+			if _, ok := t.Tokens[token]; !ok {
+				t.Tokens[token] = make(map[string]int)
+			}
+			t.Tokens[token][recordID]++
+		}
+	}
+}
+
+//removeText removes the tokens of text from the tokens of recordID
+func (t *gdbTextIndex) removeText(recordID string, text string) {
+	for _, token := range tokenize(text) {
+		records, ok := t.Tokens[token]
+		if !ok {
+			continue
+		}
+
+		if records[recordID]--; records[recordID] <= 0 {
+			delete(records, recordID)
+		}
+
+		if len(records) == 0 {
+			delete(t.Tokens, token)
+		}
+	}
+}
+
+//remove removes every record for which drop returns true from t
+func (t *gdbTextIndex) remove(drop func(recordID string) bool) {
+	for token, records := range t.Tokens {
+		for recordID := range records {
+			if drop(recordID) {
+				delete(records, recordID)
+			}
+		}
+
+		if len(records) == 0 {
+			delete(t.Tokens, token)
+		}
+	}
+}
+
+//rank returns the ids of records matching any word of text ordered by relevance.
+//A record scores higher the more often it contains the words of text and
+//the fewer of total records contain them
+func (t *gdbTextIndex) rank(text string, total int) []string {
+	scores := map[string]float64{}
+	seen := map[string]bool{}
+	for _, token := range tokenize(text) {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+
+		records := t.Tokens[token]
+		if len(records) == 0 {
+			continue
+		}
+
+		idf := math.Log(1 + float64(total)/float64(len(records)))
+		for recordID, count := range records {
+			scores[recordID] += float64(count) * idf
+		}
+	}
+
+	ids := make([]string, 0, len(scores))
+	for recordID := range scores {
+		ids = append(ids, recordID)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+//fullTextFields returns the sorted full-text fields of a schema
+func (a *Schema) fullTextFields() []string {
+	var fields []string
+	for field := range a.fullText {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+//updateTextIndex reindexes the records whose full-text fields changed from the index cache.
+//replaced holds the values the records held before (indexFile => (recordID => value)).
+//Caller must hold indexMu
+func (g *gitdb) updateTextIndex(dataset string, model Model, replaced gdbSimpleIndexCache) {
+	fields := model.GetSchema().fullTextFields()
+	indexFile := func(field string) string { return g.indexFilePath(dataset, field) }
+
+	changed := map[string]bool{}
+	for _, field := range fields {
+		for recordID := range replaced[indexFile(field)] {
+			changed[recordID] = true
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	//a text index built now already holds the values of the changed records
+	index, built := g.loadTextIndex(dataset, fields)
+	if built {
+		return
+	}
+
+	for recordID := range changed {
+		for _, field := range fields {
+			old, ok := replaced[indexFile(field)][recordID]
+			if !ok {
+				old = g.indexCache[indexFile(field)][recordID]
+			}
+			index.removeText(recordID, textValue(old))
+		}
+		index.add(g.indexCache, indexFile, recordID)
+	}
+	g.markDirty(g.textIndexFilePath(dataset))
+}
+
+//removeFromTextIndex removes records from the text index of a dataset if it is loaded.
+//The tokens removed are read from the index cache so caller must call it before the
+//records are removed from the index cache. Caller must hold indexMu
+func (g *gitdb) removeFromTextIndex(dataset string, recordIDs ...string) {
+	index, ok := g.textIndexCache[g.textIndexFilePath(dataset)]
+	if !ok || index == nil {
+		return
+	}
+
+	for _, recordID := range recordIDs {
+		for _, field := range index.Fields {
+			index.removeText(recordID, textValue(g.indexCache[g.indexFilePath(dataset, field)][recordID]))
+		}
+	}
+	g.markDirty(g.textIndexFilePath(dataset))
+}
+
+//loadTextIndex returns the text index of a dataset from the text index cache
+//or its index file. The index is rebuilt from the index cache if it does not exist
+//or was built from different fields, in which case built is true. Caller must hold indexMu
+func (g *gitdb) loadTextIndex(dataset string, fields []string) (index *gdbTextIndex, built bool) {
+	indexFile := g.textIndexFilePath(dataset)
+	index, ok := g.textIndexCache[indexFile]
+	if !ok {
+		index = g.readTextIndex(indexFile)
+	}
+
+	if index == nil || !reflect.DeepEqual(index.Fields, fields) {
+		return g.buildTextIndex(dataset, fields), true
+	}
+
+	g.textIndexCache[indexFile] = index
+	g.writeIndexFile(indexFile)
+	return index, false
+}
+
+//buildTextIndex builds the text index of a dataset from the index cache.
+//Caller must hold indexMu
+func (g *gitdb) buildTextIndex(dataset string, fields []string) *gdbTextIndex {
+	log.Info("building text index: " + dataset)
+	index := newTextIndex(fields)
+	for recordID := range g.loadTextFields(dataset, fields) {
+		index.add(g.indexCache, func(field string) string { return g.indexFilePath(dataset, field) }, recordID)
+	}
+
+	g.textIndexCache[g.textIndexFilePath(dataset)] = index
+	g.markDirty(g.textIndexFilePath(dataset))
+	return index
+}
+
+//loadTextFields reads the indexes of fields into the index cache
+//and returns the id index of a dataset. Caller must hold indexMu
+func (g *gitdb) loadTextFields(dataset string, fields []string) gdbSimpleIndex {
+	for _, field := range fields {
+		g.cacheIndex(g.indexFilePath(dataset, field))
+	}
+	return g.cacheOrNewIndex(g.indexFilePath(dataset, "id"))
+}
+
+//readTextIndex reads the text index file indexFile. Caller must hold indexMu
+func (g *gitdb) readTextIndex(indexFile string) *gdbTextIndex {
+	data, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		return nil
+	}
+	g.sealPlainIndex(indexFile, data)
+
+	data, err = openIndex(data, g.keysForFile(indexFile))
+	if err != nil {
+		log.Error(fmt.Sprintf("%s: %s", indexFile, err))
+		return nil
+	}
+
+	index, err := decodeTextIndex(data)
+	if err != nil {
+		log.Error(fmt.Sprintf("%s: %s", indexFile, err))
+		return nil
+	}
+
+	return index
+}
+
+//SearchText returns records in dataset whose full-text fields contain any word of text.
+//Records are ordered by relevance, most relevant first
+func (g *gitdb) SearchText(dataset string, text string) ([]*db.Record, error) {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
+
+	if !g.isRegistered(dataset) {
+		return nil, ErrInvalidDataset
+	}
+
+	fields := g.modelFor(dataset).GetSchema().fullTextFields()
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("gitDB: dataset %s has no full-text fields", dataset)
+	}
+
+	if len(tokenize(text)) == 0 {
+		return nil, errors.New("gitDB: search text has no words")
+	}
+
+	if err := g.waitForIndex(context.Background(), dataset); err != nil {
+		return nil, err
+	}
+
+	//make sure the indexes the text index is built from are loaded
+	ids := g.loadIndex(dataset, "id")
+	for _, field := range fields {
+		g.loadIndex(dataset, field)
+	}
+
+	g.indexMu.Lock()
+	index, _ := g.loadTextIndex(dataset, fields)
+	ranked := index.rank(text, len(ids))
+	g.indexMu.Unlock()
+
+	matches := make(map[string]string, len(ranked))
+	for _, recordID := range ranked {
+		matches[recordID] = recordID
+	}
+
+	records, err := g.hydrateRecords(context.Background(), matches)
+	if err != nil {
+		return nil, err
+	}
+
+	return orderRecords(records, ranked), nil
+}
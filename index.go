@@ -1,191 +1,594 @@
 package gitdb
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/bouggo/log"
 	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
-type gdbIndex map[string]gdbIndexValue
-type gdbIndexCache map[string]gdbIndex
+type gdbIndex map[string]gdbIndexValue             //recordID => position
+type gdbIndexCache map[string]*gdbBlockPositions   //blockFile => positions
 type gdbSimpleIndex map[string]interface{}         //recordID => value
 type gdbSimpleIndexCache map[string]gdbSimpleIndex //index => (recordID => value)
 type gdbIndexValue struct {
 	Offset int         `json:"o"`
 	Len    int         `json:"l"`
-	Value  interface{} `json:"v"`
+	Value  interface{} `json:"v,omitempty"`
 }
 
-//TODO handle deletes
 func (g *gitdb) updateIndexes(dataBlock *db.Block) {
 	g.indexMu.Lock()
 	defer g.indexMu.Unlock()
 
-	g.indexUpdated = true
 	dataset := dataBlock.Dataset().Name()
-	indexPath := g.indexPath(dataset)
 
 	log.Info("updating in-memory index: " + dataset)
-	//get line position of each record in the block
-	//p := extractPositions(dataBlock)
-
-	model := g.registry[dataset]
-	if model == nil {
-		if g.config.Factory != nil {
-			model = g.config.Factory(dataset)
-		}
-	}
 
+	model := g.modelFor(dataset)
 	if model == nil {
 		log.Error(fmt.Sprintf("model not found in registry or factory: %s", dataset))
 		return
 	}
 
-	var indexes map[string]interface{}
+	g.loadSchemaIndexes(dataset, model)
+	indexFiles, replaced := g.indexBlock(g.indexCache, g.cacheOrNewIndex, dataset, model, dataBlock)
+	g.markDirty(indexFiles...)
+	g.updateUniqueIndexes(replaced)
+	g.updateTextIndex(dataset, model, replaced)
+	g.touchIndex(dataset)
+	g.evictIndexes()
+}
+
+//indexBlock writes the index values of every record in dataBlock to cache. Indexes
+//that are not in cache are added with load. Index values a record no longer has and records
+//that have been removed from dataBlock are removed from cache. It returns the index files that changed
+//and the values changed records held before (indexFile => (recordID => value)), nil if they had none
+func (g *gitdb) indexBlock(cache gdbSimpleIndexCache, load func(indexFile string) gdbSimpleIndex, dataset string, model Model, dataBlock *db.Block) ([]string, gdbSimpleIndexCache) {
+	changed := map[string]bool{}
+	replaced := gdbSimpleIndexCache{}
+	replace := func(indexFile, recordID string) {
+		if _, ok := replaced[indexFile]; !ok {
+			replaced[indexFile] = make(gdbSimpleIndex)
+		}
+		replaced[indexFile][recordID] = cache[indexFile][recordID]
+		changed[indexFile] = true
+	}
+
 	for _, record := range dataBlock.Records() {
-		if err := record.Hydrate(model); err != nil {
+		m := newInstance(model)
+		if err := record.Hydrate(m); err != nil {
 			log.Error(fmt.Sprintf("record.Hydrate failed: %s %s", record.ID(), err))
+			continue
 		}
-		indexes = model.GetSchema().indexes
 
 		//append index for id
 		recordID := record.ID()
-		indexes["id"] = recordID
+		indexes := map[string]interface{}{"id": recordID}
+		for name, value := range m.GetSchema().indexes {
+			indexes[name] = value
+		}
 
 		for name, value := range indexes {
-			indexFile := filepath.Join(indexPath, name+".json")
-			if _, ok := g.indexCache[indexFile]; !ok {
-				g.indexCache[indexFile] = g.readIndex(indexFile)
+			indexFile := g.indexFilePath(dataset, name)
+			if _, ok := cache[indexFile]; !ok {
+				cache[indexFile] = load(indexFile)
+			}
+
+			value = normalizeIndexValue(value)
+			if old, ok := cache[indexFile][recordID]; !ok || !reflect.DeepEqual(old, value) {
+				replace(indexFile, recordID)
+				cache[indexFile][recordID] = value
+			}
+		}
+
+		//remove record from indexes it no longer has a value for
+		for indexFile, index := range cache {
+			if _, ok := indexes[indexName(indexFile)]; ok || !g.isDatasetIndex(indexFile, dataset) {
+				continue
+			}
+
+			if _, ok := index[recordID]; ok {
+				replace(indexFile, recordID)
+				delete(index, recordID)
+			}
+		}
+	}
+
+	//remove records that no longer exist in the block
+	block := strings.TrimSuffix(filepath.Base(dataBlock.Path()), ".json")
+	for recordID := range cache[g.indexFilePath(dataset, "id")] {
+		if _, b, _, _ := ParseID(recordID); b != block {
+			continue
+		}
+
+		if _, err := dataBlock.Get(recordID); err != nil {
+			for indexFile, index := range cache {
+				if _, ok := index[recordID]; ok && g.isDatasetIndex(indexFile, dataset) {
+					replace(indexFile, recordID)
+					delete(index, recordID)
+				}
 			}
-			//g.indexCache[indexFile][recordID] = gdbIndexValue{
-			//	Offset: p[recordID][0],
-			//	Len:    p[recordID][1],
-			//	Value:  value,
-			//}
-			g.indexCache[indexFile][recordID] = value
 		}
 	}
+
+	var indexFiles []string
+	for indexFile := range changed {
+		indexFiles = append(indexFiles, indexFile)
+	}
+	return indexFiles, replaced
 }
 
-func (g *gitdb) flushIndex() error {
+//removeFromIndexes removes records from the indexes of a dataset. Only the indexes of
+//the dataset's schema are read or all indexes if the dataset is not registered
+func (g *gitdb) removeFromIndexes(dataset string, recordIDs ...string) {
 	g.indexMu.Lock()
 	defer g.indexMu.Unlock()
 
-	if g.indexUpdated {
-		log.Test("flushing index")
-		for indexFile, data := range g.indexCache {
+	if model := g.modelFor(dataset); model != nil {
+		g.loadSchemaIndexes(dataset, model)
+	} else {
+		g.loadDatasetIndexes(dataset)
+	}
+	g.removeFromUniqueIndexes(dataset, recordIDs...)
+	g.removeFromTextIndex(dataset, recordIDs...)
+	g.markDirty(removeFromIndexCache(g.indexCache, g.indexPath(dataset), recordIDs...)...)
+	g.touchIndex(dataset)
+	g.evictIndexes()
+}
 
-			indexPath := filepath.Dir(indexFile)
-			if _, err := os.Stat(indexPath); err != nil {
-				if err := os.MkdirAll(indexPath, 0755); err != nil {
-					log.Error("Failed to write to index: " + indexFile)
-					return err
-				}
-			}
+//removeFromIndexCache removes records from the indexes in cache
+//found at indexPath and returns the index files that changed
+func removeFromIndexCache(cache gdbSimpleIndexCache, indexPath string, recordIDs ...string) []string {
+	var changed []string
+	for indexFile, index := range cache {
+		if filepath.Dir(indexFile) != indexPath {
+			continue
+		}
 
-			// indexBytes, err := json.MarshalIndent(data, "", "\t")
-			indexBytes, err := json.Marshal(data)
-			if err != nil {
-				log.Error("Failed to write to index [" + indexFile + "]: " + err.Error())
-				return err
-			}
+		n := len(index)
+		for _, recordID := range recordIDs {
+			delete(index, recordID)
+		}
+
+		if len(index) != n {
+			changed = append(changed, indexFile)
+		}
+	}
+	return changed
+}
+
+//markDirty marks index files that must be written by the next flushIndex.
+//Caller must hold indexMu
+func (g *gitdb) markDirty(indexFiles ...string) {
+	//index files of a read only connection are never written
+	if g.readOnly {
+		return
+	}
+
+	if g.dirtyIndexes == nil {
+		g.dirtyIndexes = make(map[string]bool)
+	}
 
-			if err := ioutil.WriteFile(indexFile, indexBytes, 0744); err != nil {
-				log.Error("Failed to write to index: " + indexFile)
-				return err
+	for _, indexFile := range indexFiles {
+		g.dirtyIndexes[indexFile] = true
+	}
+}
+
+//indexName returns the name of the index held in indexFile
+func indexName(indexFile string) string {
+	return strings.TrimSuffix(filepath.Base(indexFile), filepath.Ext(indexFile))
+}
+
+//legacyIndexFilePath returns the path of the json index file
+//that held the index of indexFile before the binary index format
+func legacyIndexFilePath(indexFile string) string {
+	return strings.TrimSuffix(indexFile, filepath.Ext(indexFile)) + ".json"
+}
+
+//loadDatasetIndexes reads all index files of a dataset that
+//are not in the index cache into the index cache. Caller must hold indexMu
+func (g *gitdb) loadDatasetIndexes(dataset string) {
+	indexPath := g.indexPath(dataset)
+	files, err := ioutil.ReadDir(indexPath)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if ext != ".idx" && ext != ".json" {
+			continue
+		}
+
+		indexFile := g.indexFilePath(dataset, indexName(file.Name()))
+		if _, ok := g.indexCache[indexFile]; !ok {
+			if index, ok := g.readIndex(indexFile); ok {
+				g.indexCache[indexFile] = index
+				g.writeIndexFile(indexFile)
 			}
 		}
-		g.indexUpdated = false
+	}
+}
+
+//newIndex returns a new empty index for indexFile
+func newIndex(indexFile string) gdbSimpleIndex {
+	return make(gdbSimpleIndex)
+}
+
+func (g *gitdb) isDatasetIndex(indexFile, dataset string) bool {
+	return filepath.Dir(indexFile) == g.indexPath(dataset)
+}
+
+//flushIndex writes index files that changed since the last flush
+func (g *gitdb) flushIndex() error {
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	if len(g.dirtyIndexes) > 0 {
+		log.Test("flushing index")
 	}
 
+	for indexFile := range g.dirtyIndexes {
+		if _, err := g.writeIndexFile(indexFile); err != nil {
+			return err
+		}
+	}
+
+	g.evictIndexes()
 	return nil
 }
 
-func (g *gitdb) readIndex(indexFile string) gdbSimpleIndex {
-	rMap := make(gdbSimpleIndex)
-	if _, err := os.Stat(indexFile); err == nil {
-		data, err := ioutil.ReadFile(indexFile)
-		if err == nil {
-			err = json.Unmarshal(data, &rMap)
+//writeIndexFile writes the cached index of indexFile if it is dirty and reports
+//whether the index file is up to date. Caller must hold indexMu
+func (g *gitdb) writeIndexFile(indexFile string) (bool, error) {
+	if !g.dirtyIndexes[indexFile] {
+		return true, nil
+	}
+
+	var indexBytes []byte
+	if textIndex, ok := g.textIndexCache[indexFile]; ok {
+		indexBytes = encodeTextIndex(textIndex)
+	} else if index, ok := g.indexCache[indexFile]; ok {
+		var err error
+		if indexBytes, err = encodeIndex(index); err != nil {
+			log.Error("Failed to write to index [" + indexFile + "]: " + err.Error())
+			return false, err
+		}
+	} else {
+		delete(g.dirtyIndexes, indexFile)
+		return true, nil
+	}
+
+	dataset := filepath.Base(filepath.Dir(indexFile))
+	if g.sealsIndexes(dataset) {
+		keys := g.keysFor(dataset)
+		//without a key the index is only kept in memory
+		if len(keys.Key()) == 0 {
+			log.Error("Not writing index without an encryption key: " + indexFile)
+			delete(g.dirtyIndexes, indexFile)
+			return false, nil
 		}
 
-		if err != nil {
-			log.Error(err.Error())
+		var err error
+		if indexBytes, err = sealIndex(indexBytes, keys); err != nil {
+			log.Error("Failed to write to index [" + indexFile + "]: " + err.Error())
+			return false, err
 		}
 	}
-	return rMap
+
+	if err := os.MkdirAll(filepath.Dir(indexFile), 0755); err != nil {
+		log.Error("Failed to write to index: " + indexFile)
+		return false, err
+	}
+
+	if err := writeFileAtomic(indexFile, indexBytes, 0744); err != nil {
+		log.Error("Failed to write to index: " + indexFile)
+		return false, err
+	}
+
+	//the binary index file replaces any json index file
+	if err := os.Remove(legacyIndexFilePath(indexFile)); err != nil && !os.IsNotExist(err) {
+		log.Error(err.Error())
+	}
+
+	delete(g.dirtyIndexes, indexFile)
+	return true, nil
 }
 
-func (g *gitdb) buildIndexSmart(changedFiles []string) {
-	for _, blockFile := range changedFiles {
-		log.Info("Building index for block: " + blockFile)
-		block := db.LoadBlock(filepath.Join(g.dbDir(), blockFile), g.config.EncryptionKey)
-		g.updateIndexes(block)
+//cacheIndex returns the cached index of indexFile. The index file is read into the
+//index cache if it is not cached. ok is false if it is neither cached nor found.
+//Caller must hold indexMu
+func (g *gitdb) cacheIndex(indexFile string) (index gdbSimpleIndex, ok bool) {
+	if index, ok = g.indexCache[indexFile]; !ok {
+		if index, ok = g.readIndex(indexFile); ok {
+			g.indexCache[indexFile] = index
+			g.writeIndexFile(indexFile)
+		}
 	}
-	log.Info("Building index complete")
+
+	if ok {
+		g.indexTick++
+		if g.indexUsed == nil {
+			g.indexUsed = make(map[string]uint64)
+		}
+		g.indexUsed[indexFile] = g.indexTick
+	}
+	return index, ok
 }
 
-func (g *gitdb) buildIndexTargeted(target string) {
-	ds := db.LoadDataset(filepath.Join(g.dbDir(), target), g.config.EncryptionKey)
-	for _, block := range ds.Blocks() {
-		g.updateIndexes(block)
+//cacheOrNewIndex returns the cached index of indexFile or a new empty index
+//if the index file does not exist. Caller must hold indexMu
+func (g *gitdb) cacheOrNewIndex(indexFile string) gdbSimpleIndex {
+	if index, ok := g.cacheIndex(indexFile); ok {
+		return index
 	}
+	return make(gdbSimpleIndex)
 }
 
-func (g *gitdb) buildIndexFull() {
-	datasets := db.LoadDatasets(g.dbDir(), g.config.EncryptionKey)
-	for _, ds := range datasets {
-		g.buildIndexTargeted(ds.Name())
+//loadSchemaIndexes reads the id index and the indexes of the schema of model into the
+//index cache. Index files of the dataset the schema does not have are not read.
+//Caller must hold indexMu
+func (g *gitdb) loadSchemaIndexes(dataset string, model Model) {
+	g.cacheIndex(g.indexFilePath(dataset, "id"))
+	for name := range model.GetSchema().indexes {
+		g.cacheIndex(g.indexFilePath(dataset, name))
 	}
-	if err := g.flushIndex(); err != nil {
-		log.Error("gitDB: flushIndex failed: " + err.Error())
+}
+
+//evictIndexes removes the least recently used indexes from the index cache until it
+//holds at most Config.IndexCacheFiles indexes. Indexes that changed are written before
+//they are evicted and indexes that cannot be written are kept. Caller must hold indexMu
+func (g *gitdb) evictIndexes() {
+	limit := g.config.IndexCacheFiles
+	if limit <= 0 || len(g.indexCache) <= limit {
+		return
+	}
+
+	indexFiles := make([]string, 0, len(g.indexCache))
+	for indexFile := range g.indexCache {
+		indexFiles = append(indexFiles, indexFile)
+	}
+	sort.Slice(indexFiles, func(i, j int) bool {
+		return g.indexUsed[indexFiles[i]] < g.indexUsed[indexFiles[j]]
+	})
+
+	for _, indexFile := range indexFiles {
+		if len(g.indexCache) <= limit {
+			return
+		}
+
+		if written, err := g.writeIndexFile(indexFile); err != nil || !written {
+			continue
+		}
+
+		delete(g.indexCache, indexFile)
+		delete(g.indexUsed, indexFile)
+		delete(g.uniqueIndexes, indexFile)
+	}
+}
+
+//loadIndex returns the named index of a dataset. Only the requested index file
+//is read and the dataset's indexes are built if it is not found
+func (g *gitdb) loadIndex(dataset, index string) gdbSimpleIndex {
+	indexFile := g.indexFilePath(dataset, index)
+
+	g.indexMu.Lock()
+	idx, ok := g.cacheIndex(indexFile)
+	g.indexMu.Unlock()
+
+	if !ok {
+		g.buildIndexTargeted(dataset)
+
+		g.indexMu.Lock()
+		idx = g.indexCache[indexFile]
+		g.indexMu.Unlock()
 	}
+
+	g.indexMu.Lock()
+	g.evictIndexes()
+	g.indexMu.Unlock()
+
+	return idx
 }
 
-//extractPositions returns the position of all records in a block
-//as they would appear in the physical block file
-//a block can contain records from multiple physical block files
-//especially when *gitdb.doFetch is called so proceed with caution
-func extractPositions(b *db.Block) map[string][]int {
-	var positions = map[string][]int{}
+//readIndex reads indexFile or the json index file it replaces.
+//ok is false if neither exists. Caller must hold indexMu
+func (g *gitdb) readIndex(indexFile string) (index gdbSimpleIndex, ok bool) {
+	data, err := ioutil.ReadFile(indexFile)
+	if err == nil {
+		g.sealPlainIndex(indexFile, data)
+		if data, err = openIndex(data, g.keysForFile(indexFile)); err == nil {
+			index, err = decodeIndex(data)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("%s: %s", indexFile, err))
+			return nil, false
+		}
+		return index, true
+	}
 
-	data, err := json.MarshalIndent(b, "", "\t")
+	data, err = ioutil.ReadFile(legacyIndexFilePath(indexFile))
 	if err != nil {
+		return nil, false
+	}
+	g.sealPlainIndex(indexFile, data)
+
+	index = make(gdbSimpleIndex)
+	if err := json.Unmarshal(data, &index); err != nil {
 		log.Error(err.Error())
+		return nil, false
+	}
+
+	return index, true
+}
+
+//sealPlainIndex marks indexFile dirty if data, the content of its index file, is
+//plain text but the index files of its dataset are sealed e.g because its records were
+//encrypted after the index file was written. The index file is sealed when it is written.
+//Caller must hold indexMu
+func (g *gitdb) sealPlainIndex(indexFile string, data []byte) {
+	if !isSealedIndex(data) && g.sealsIndexes(filepath.Base(filepath.Dir(indexFile))) {
+		g.markDirty(indexFile)
 	}
+}
 
-	offset := 3
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) != "{" && strings.TrimSpace(line) != "}" {
-			length := len(line)
+//IndexDrift describes the differences found between
+//the blocks of a dataset and its indexes
+type IndexDrift struct {
+	Dataset string
+	//Missing holds ids of records found in blocks but not in the index
+	Missing []string
+	//Stale holds ids of records found in the index but not in blocks
+	Stale []string
+	//Changed holds ids of records whose index values differ from the record
+	Changed []string
+	//Repaired is true if the index was rebuilt to remove the drift
+	Repaired bool
+}
 
-			record := strings.SplitN(line, ":", 2)
-			if len(record) != 2 {
-				continue
-			}
+//InSync returns true if no drift was found
+func (d *IndexDrift) InSync() bool {
+	return len(d.Missing) == 0 && len(d.Stale) == 0 && len(d.Changed) == 0
+}
+
+//CheckIndex compares the indexes of a dataset with its blocks
+//and rebuilds the indexes if repair is true and drift is found
+func (g *gitdb) CheckIndex(dataset string, repair bool) (*IndexDrift, error) {
+	if repair {
+		g.connMu.Lock()
+		defer g.connMu.Unlock()
+	} else {
+		g.connMu.RLock()
+		defer g.connMu.RUnlock()
+	}
 
-			recordID := strings.TrimSpace(record[0])
-			recordID = recordID[1 : len(recordID)-1]
-			positions[recordID] = []int{offset, length}
+	return g.checkIndex(dataset, repair)
+}
 
-			//account for newline
-			offset += length + 1
+func (g *gitdb) checkIndex(dataset string, repair bool) (*IndexDrift, error) {
+	if !g.isRegistered(dataset) {
+		return nil, ErrInvalidDataset
+	}
+
+	model := g.modelFor(dataset)
+	fresh := make(gdbSimpleIndexCache)
+	ds := db.LoadDataset(filepath.Join(g.dbDir(), dataset), g.keysFor(dataset))
+	for _, block := range ds.Blocks() {
+		g.indexBlock(fresh, newIndex, dataset, model, block)
+	}
+
+	g.indexMu.Lock()
+	g.loadDatasetIndexes(dataset)
+	drift := g.indexDrift(dataset, fresh)
+	if repair && !drift.InSync() {
+		g.replaceDatasetIndexes(dataset, model, fresh)
+		drift.Repaired = true
+	}
+	g.indexMu.Unlock()
+
+	if drift.Repaired {
+		if err := g.flushIndex(); err != nil {
+			return drift, err
+		}
+	}
+
+	return drift, nil
+}
+
+//indexDrift compares the cached indexes of a dataset with fresh
+func (g *gitdb) indexDrift(dataset string, fresh gdbSimpleIndexCache) *IndexDrift {
+	drift := &IndexDrift{Dataset: dataset}
+	idIndex := g.indexFilePath(dataset, "id")
+	for recordID := range fresh[idIndex] {
+		if _, ok := g.indexCache[idIndex][recordID]; !ok {
+			drift.Missing = append(drift.Missing, recordID)
+		}
+	}
+
+	for recordID := range g.indexCache[idIndex] {
+		if _, ok := fresh[idIndex][recordID]; !ok {
+			drift.Stale = append(drift.Stale, recordID)
+		}
+	}
+
+	indexFiles := map[string]bool{}
+	for indexFile := range fresh {
+		indexFiles[indexFile] = true
+	}
+	for indexFile := range g.indexCache {
+		if g.isDatasetIndex(indexFile, dataset) {
+			indexFiles[indexFile] = true
+		}
+	}
+
+	changed := map[string]bool{}
+	for indexFile := range indexFiles {
+		for recordID := range fresh[idIndex] {
+			want, wok := fresh[indexFile][recordID]
+			got, gok := g.indexCache[indexFile][recordID]
+			if wok != gok || (wok && !reflect.DeepEqual(want, got)) {
+				if _, ok := g.indexCache[idIndex][recordID]; ok {
+					changed[recordID] = true
+				}
+			}
 		}
 	}
 
-	if len(positions) < 1 {
-		panic("no positions extracted from: " + b.Path())
+	for recordID := range changed {
+		drift.Changed = append(drift.Changed, recordID)
 	}
 
-	return positions
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Stale)
+	sort.Strings(drift.Changed)
+	return drift
+}
+
+func (g *gitdb) buildIndexSmart(changedFiles []string) {
+	for _, blockFile := range changedFiles {
+		log.Info("Building index for block: " + blockFile)
+		block := db.LoadBlock(filepath.Join(g.dbDir(), blockFile), g.keysForFile(blockFile))
+		g.updateIndexes(block)
+	}
+	log.Info("Building index complete")
+}
+
+//buildIndexTargeted builds the index of target or waits
+//for the build to finish if it is already being built
+func (g *gitdb) buildIndexTargeted(target string) {
+	build, ok := g.startBuild(target)
+	if !ok {
+		<-build.done
+		return
+	}
+	g.buildIndex(target, build)
+}
+
+//startFullBuild registers a build of every dataset's index so that
+//queries wait for the builds even if they start before buildIndexFull runs
+func (g *gitdb) startFullBuild() map[string]*indexBuild {
+	builds := map[string]*indexBuild{}
+	for _, ds := range db.LoadDatasets(g.dbDir(), g.keysFor) {
+		if build, ok := g.startBuild(ds.Name()); ok {
+			builds[ds.Name()] = build
+		}
+	}
+	return builds
+}
+
+func (g *gitdb) buildIndexFull(builds map[string]*indexBuild) {
+	for dataset, build := range builds {
+		g.buildIndex(dataset, build)
+	}
+	if err := g.flushIndex(); err != nil {
+		log.Error("gitDB: flushIndex failed: " + err.Error())
+	}
 }
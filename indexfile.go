@@ -0,0 +1,391 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+)
+
+//Index files hold entries sorted by key. Keys share long prefixes
+//(e.g Dataset/Block/) so each key is stored as the length of the prefix
+//it shares with the previous key followed by the rest of the key.
+//
+//	index file:      magic version count entry...
+//	entry:           key value
+//	text index file: magic version fields count token...
+//	token:           key count (key occurrences)...
+//	sealed file:     magic version envelope
+//
+//Index files of encrypted datasets are sealed: the whole index file
+//is encrypted into an envelope so no index value is kept in plain text.
+
+const (
+	indexFileVersion = 1
+
+	valueNil   byte = 0
+	valueText  byte = 1
+	valueFloat byte = 2
+	valueTrue  byte = 3
+	valueFalse byte = 4
+	valueJSON  byte = 5
+)
+
+var (
+	indexFileMagic       = []byte("GDBI")
+	textIndexFileMagic   = []byte("GDBT")
+	sealedIndexFileMagic = []byte("GDBE")
+
+	errBadIndexFile = errors.New("gitDB: bad index file")
+)
+
+type indexWriter struct {
+	buf  bytes.Buffer
+	last string
+}
+
+func newIndexWriter(magic []byte) *indexWriter {
+	w := &indexWriter{}
+	w.buf.Write(magic)
+	w.buf.WriteByte(indexFileVersion)
+	return w
+}
+
+func (w *indexWriter) uvarint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func (w *indexWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+//key writes a key of a sorted sequence of keys
+func (w *indexWriter) key(key string) {
+	shared := 0
+	for shared < len(key) && shared < len(w.last) && key[shared] == w.last[shared] {
+		shared++
+	}
+
+	w.uvarint(uint64(shared))
+	w.bytes([]byte(key[shared:]))
+	w.last = key
+}
+
+func (w *indexWriter) value(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		w.buf.WriteByte(valueNil)
+	case string:
+		w.buf.WriteByte(valueText)
+		w.bytes([]byte(v))
+	case float64:
+		w.buf.WriteByte(valueFloat)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		w.buf.Write(b[:])
+	case bool:
+		if v {
+			w.buf.WriteByte(valueTrue)
+		} else {
+			w.buf.WriteByte(valueFalse)
+		}
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.buf.WriteByte(valueJSON)
+		w.bytes(b)
+	}
+
+	return nil
+}
+
+type indexReader struct {
+	r    *bytes.Reader
+	last string
+}
+
+func newIndexReader(data []byte, magic []byte) (*indexReader, error) {
+	if len(data) < len(magic)+1 || !bytes.Equal(data[:len(magic)], magic) {
+		return nil, errBadIndexFile
+	}
+
+	if data[len(magic)] != indexFileVersion {
+		return nil, errBadIndexFile
+	}
+
+	return &indexReader{r: bytes.NewReader(data[len(magic)+1:])}, nil
+}
+
+func (r *indexReader) uvarint() (uint64, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return 0, errBadIndexFile
+	}
+	return n, nil
+}
+
+func (r *indexReader) bytes() ([]byte, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	if n > uint64(r.r.Len()) {
+		return nil, errBadIndexFile
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, errBadIndexFile
+	}
+	return b, nil
+}
+
+func (r *indexReader) key() (string, error) {
+	shared, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+
+	if shared > uint64(len(r.last)) {
+		return "", errBadIndexFile
+	}
+
+	rest, err := r.bytes()
+	if err != nil {
+		return "", err
+	}
+
+	r.last = r.last[:shared] + string(rest)
+	return r.last, nil
+}
+
+func (r *indexReader) value() (interface{}, error) {
+	kind, err := r.r.ReadByte()
+	if err != nil {
+		return nil, errBadIndexFile
+	}
+
+	switch kind {
+	case valueNil:
+		return nil, nil
+	case valueText:
+		b, err := r.bytes()
+		return string(b), err
+	case valueFloat:
+		var b [8]byte
+		if _, err := io.ReadFull(r.r, b[:]); err != nil {
+			return nil, errBadIndexFile
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+	case valueTrue:
+		return true, nil
+	case valueFalse:
+		return false, nil
+	case valueJSON:
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, errBadIndexFile
+		}
+		return v, nil
+	}
+
+	return nil, errBadIndexFile
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//encodeIndex encodes index in the index file format
+func encodeIndex(index gdbSimpleIndex) ([]byte, error) {
+	w := newIndexWriter(indexFileMagic)
+	w.uvarint(uint64(len(index)))
+	for _, recordID := range sortedKeys(index) {
+		w.key(recordID)
+		if err := w.value(index[recordID]); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+//decodeIndex decodes an index file
+func decodeIndex(data []byte) (gdbSimpleIndex, error) {
+	r, err := newIndexReader(data, indexFileMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(gdbSimpleIndex)
+	for i := uint64(0); i < count; i++ {
+		recordID, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+
+		if index[recordID], err = r.value(); err != nil {
+			return nil, err
+		}
+	}
+
+	return index, nil
+}
+
+//encodeTextIndex encodes index in the text index file format
+func encodeTextIndex(index *gdbTextIndex) []byte {
+	w := newIndexWriter(textIndexFileMagic)
+	w.uvarint(uint64(len(index.Fields)))
+	for _, field := range index.Fields {
+		w.bytes([]byte(field))
+	}
+
+	tokens := make([]string, 0, len(index.Tokens))
+	for token := range index.Tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	w.uvarint(uint64(len(tokens)))
+	for _, token := range tokens {
+		w.key(token)
+
+		records := index.Tokens[token]
+		recordIDs := make([]string, 0, len(records))
+		for recordID := range records {
+			recordIDs = append(recordIDs, recordID)
+		}
+		sort.Strings(recordIDs)
+
+		//record ids are prefix compressed within a token
+		w.uvarint(uint64(len(recordIDs)))
+		tokenKey := w.last
+		w.last = ""
+		for _, recordID := range recordIDs {
+			w.key(recordID)
+			w.uvarint(uint64(records[recordID]))
+		}
+		w.last = tokenKey
+	}
+
+	return w.buf.Bytes()
+}
+
+//decodeTextIndex decodes a text index file
+func decodeTextIndex(data []byte) (*gdbTextIndex, error) {
+	r, err := newIndexReader(data, textIndexFileMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldCount, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for i := uint64(0); i < fieldCount; i++ {
+		field, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, string(field))
+	}
+
+	index := newTextIndex(fields)
+	tokenCount, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < tokenCount; i++ {
+		token, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+
+		recordCount, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+
+		records := make(map[string]int, recordCount)
+		r.last = ""
+		for j := uint64(0); j < recordCount; j++ {
+			recordID, err := r.key()
+			if err != nil {
+				return nil, err
+			}
+
+			count, err := r.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			records[recordID] = int(count)
+		}
+		r.last = token
+
+		index.Tokens[token] = records
+	}
+
+	return index, nil
+}
+
+//sealIndex encrypts the data of an index file with keys
+func sealIndex(data []byte, keys *crypto.KeyRing) ([]byte, error) {
+	envelope, err := keys.Encrypt(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	w := newIndexWriter(sealedIndexFileMagic)
+	w.buf.WriteString(envelope)
+	return w.buf.Bytes(), nil
+}
+
+//isSealedIndex reports whether data is a sealed index file
+func isSealedIndex(data []byte) bool {
+	return bytes.HasPrefix(data, sealedIndexFileMagic)
+}
+
+//openIndex returns the data of an index file, decrypting it with keys if it is sealed
+func openIndex(data []byte, keys *crypto.KeyRing) ([]byte, error) {
+	if !isSealedIndex(data) {
+		return data, nil
+	}
+
+	if _, err := newIndexReader(data, sealedIndexFileMagic); err != nil {
+		return nil, err
+	}
+
+	plain, err := keys.Decrypt(string(data[len(sealedIndexFileMagic)+1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(plain), nil
+}
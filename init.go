@@ -11,6 +11,7 @@ import (
 )
 
 var mu sync.Mutex
+var connsMu sync.RWMutex
 var conns map[string]GitDb
 
 // Open opens a connection to GitDB
@@ -21,14 +22,10 @@ func Open(config *Config) (GitDb, error) {
 		return nil, err
 	}
 
-	if conns == nil {
-		conns = make(map[string]GitDb)
-	}
-
 	if cfg.Mock {
 		conn := newMockConnection()
 		conn.configure(cfg)
-		conns[cfg.ConnectionName] = conn
+		addConn(cfg.ConnectionName, conn)
 		return conn, nil
 	}
 
@@ -72,13 +69,26 @@ func Open(config *Config) (GitDb, error) {
 		conn.loopStarted = true
 	}
 
-	conns[cfg.ConnectionName] = conn
+	addConn(cfg.ConnectionName, conn)
 	return conn, nil
 }
 
+func addConn(name string, conn GitDb) {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+
+	if conns == nil {
+		conns = make(map[string]GitDb)
+	}
+	conns[name] = conn
+}
+
 // Conn returns the last connection started by Open(*Config)
 // if you opened more than one connection use GetConn(name) instead
 func Conn() GitDb {
+	connsMu.RLock()
+	defer connsMu.RUnlock()
+
 	if len(conns) > 1 {
 		panic("Multiple gitdb connections found. Use GetConn function instead")
 	}
@@ -102,6 +112,9 @@ func Conn() GitDb {
 
 // GetConn returns a specific gitdb connection by name
 func GetConn(name string) GitDb {
+	connsMu.RLock()
+	defer connsMu.RUnlock()
+
 	if _, ok := conns[name]; !ok {
 		panic("No gitdb connection found")
 	}
@@ -116,10 +129,24 @@ func (g *gitdb) boot() error {
 		return err
 	}
 
+	// the salt of a passphrase key is read from the database so it must be set up first
+	if err := g.createSalt(); err != nil {
+		return err
+	}
+
+	if err := g.resolveKey(); err != nil {
+		return err
+	}
+
+	// repair files left partially written by a crash
+	if err := g.recoverFiles(); err != nil {
+		return err
+	}
+
 	// rebuild index if we have to
 	if _, err := os.Stat(g.indexDir()); err != nil {
 		// no index directory found so we need to re-index the whole db
-		go g.buildIndexFull()
+		go g.buildIndexFull(g.startFullBuild())
 	}
 
 	return nil
@@ -1,6 +1,9 @@
 package gitdb_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gogitdb/gitdb/v2"
@@ -23,3 +26,49 @@ func TestGetConn(t *testing.T) {
 		t.Errorf("connection don't match")
 	}
 }
+
+func TestOpenRecoversPartialWrites(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessage()); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+	testDb.Close()
+
+	//simulate a crash in the middle of writing a block and an index
+	cfg := getConfig()
+	blockFile := filepath.Join(cfg.DBPath, "data", "Message", "b0.json")
+	tempFile := filepath.Join(cfg.DBPath, "data", "Message", ".b0.json.1234.gdbtmp")
+	indexFile := filepath.Join(cfg.DBPath, ".gitdb", "index", "Message", "From.idx")
+
+	b, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, data := range map[string][]byte{blockFile: b[:len(b)/2], tempFile: b[:10], indexFile: []byte("GDBI")} {
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Errorf("temp file should be removed: %v", err)
+	}
+
+	records, err := testDb.Fetch("Message")
+	if err != nil || len(records) != 3 {
+		t.Errorf("testDb.Fetch want: 3 records, got: %d (%v)", len(records), err)
+	}
+
+	records, err = testDb.Search("Message", []*gitdb.SearchParam{{Index: "From", Value: "alice@example.com"}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 3 {
+		t.Errorf("testDb.Search want: 3 records, got: %d (%v)", len(records), err)
+	}
+}
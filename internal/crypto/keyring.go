@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"sync"
+
+	"github.com/gogitdb/gitdb/v2/internal/errors"
+)
+
+//KeyRing holds the key messages are encrypted with and earlier keys messages
+//are still decrypted with. A nil KeyRing has no keys
+type KeyRing struct {
+	mu   sync.RWMutex
+	keys []string
+}
+
+//NewKeyRing returns a KeyRing that encrypts with key and decrypts with key and older.
+//Empty keys are ignored
+func NewKeyRing(key string, older ...string) *KeyRing {
+	k := &KeyRing{}
+	for _, key := range append([]string{key}, older...) {
+		k.add(key)
+	}
+	return k
+}
+
+func (k *KeyRing) add(key string) {
+	if len(key) == 0 || contains(k.keys, key) {
+		return
+	}
+
+	k.keys = append(k.keys, key)
+}
+
+func contains(keys []string, key string) bool {
+	for _, existing := range keys {
+		if existing == key {
+			return true
+		}
+	}
+	return false
+}
+
+//Key returns the key messages are encrypted with
+func (k *KeyRing) Key() string {
+	if k == nil {
+		return ""
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) == 0 {
+		return ""
+	}
+	return k.keys[0]
+}
+
+//Encrypt message with the key of the ring
+func (k *KeyRing) Encrypt(message string) (string, error) {
+	key := k.Key()
+	if len(key) == 0 {
+		return "", errors.ErrNoEncryptionKey
+	}
+
+	return Encrypt(key, message)
+}
+
+//Decrypt message with the key of the ring it was encrypted with
+func (k *KeyRing) Decrypt(secureMessage string) (string, error) {
+	if k == nil {
+		return "", errors.ErrNoEncryptionKey
+	}
+
+	k.mu.RLock()
+	keys := k.keys
+	k.mu.RUnlock()
+
+	return Decrypt(keys, secureMessage)
+}
+
+//Empty reports whether the ring has no keys to decrypt messages with
+func (k *KeyRing) Empty() bool {
+	if k == nil {
+		return true
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys) == 0
+}
+
+//Older returns the keys messages are only decrypted with
+func (k *KeyRing) Older() []string {
+	if k == nil {
+		return nil
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) < 2 {
+		return nil
+	}
+	return append([]string{}, k.keys[1:]...)
+}
+
+//Rotate makes key the key messages are encrypted with. older and the
+//earlier keys of the ring are kept so messages encrypted with them can be decrypted
+func (k *KeyRing) Rotate(key string, older ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := k.keys
+	k.keys = nil
+	for _, key := range append(append([]string{key}, older...), keys...) {
+		k.add(key)
+	}
+}
@@ -1,54 +1,182 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/errors"
 )
 
-//Encrypt message with key
-func Encrypt(key string, message string) string {
-	plainText := []byte(message)
+//envelopePrefix marks messages encrypted by Encrypt. It is not part of the
+//base64 alphabet so envelopes are never mistaken for legacy AES-CFB messages
+const envelopePrefix = "gdb:"
 
-	block, err := aes.NewCipher([]byte(key))
+//envelope header fields. The header is authenticated along with the message.
+//Version 1 envelopes have no key id and are decrypted by trying every key
+const (
+	envelopeV1 byte = 1
+	envelopeV2 byte = 2
+	algAESGCM  byte = 1
+)
+
+const keyIDSize = 4
+
+//keyID identifies key in the header of an envelope without revealing the key
+func keyID(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:keyIDSize]
+}
+
+//ValidKey returns an error if key cannot be used to encrypt messages
+func ValidKey(key string) error {
+	if len(key) == 0 {
+		return errors.ErrNoEncryptionKey
+	}
+
+	if _, err := aes.NewCipher([]byte(key)); err != nil {
+		return errors.ErrEncryptionKey
+	}
+
+	return nil
+}
+
+//Encrypt message with key using AES-GCM. The result is an envelope holding the
+//envelope version, the algorithm id, the key id, the nonce and the sealed message
+func Encrypt(key string, message string) (string, error) {
+	aead, err := newGCM(key)
 	if err != nil {
-		println(err.Error())
-		return ""
+		return "", err
 	}
 
-	//IV needs to be unique, but doesn't have to be secure.
-	//It's common to put it at the beginning of the ciphertext.
-	cipherText := make([]byte, aes.BlockSize+len(plainText))
-	iv := cipherText[:aes.BlockSize]
-	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
-		println(err.Error())
-		return ""
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("gitDB: failed to generate nonce: %s", err)
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(cipherText[aes.BlockSize:], plainText)
+	header := append([]byte{envelopeV2, algAESGCM}, keyID(key)...)
+	envelope := append(header, nonce...)
+	envelope = aead.Seal(envelope, nonce, []byte(message), header)
 
-	//returns to base64 encoded string
-	encmess := base64.URLEncoding.EncodeToString(cipherText)
-	return encmess
+	return envelopePrefix + base64.RawURLEncoding.EncodeToString(envelope), nil
 }
 
-//Decrypt message with key
-func Decrypt(key string, secureMessage string) string {
-	cipherText, err := base64.URLEncoding.DecodeString(secureMessage)
+//Decrypt message with one of keys. The key named in the envelope of message is
+//used if it has one otherwise each key is tried in turn. Messages encrypted with
+//AES-CFB by earlier versions of gitdb are still decrypted but as they are not
+//authenticated, a wrong key is only detected by the result not being JSON
+func Decrypt(keys []string, secureMessage string) (string, error) {
+	if len(keys) == 0 {
+		return "", errors.ErrNoEncryptionKey
+	}
+
+	if !strings.HasPrefix(secureMessage, envelopePrefix) {
+		return tryKeys(keys, func(key string) (string, error) { return decryptCFB(key, secureMessage) })
+	}
+
+	envelope, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(secureMessage, envelopePrefix))
+	if err != nil || len(envelope) < 2 {
+		return "", fmt.Errorf("%w: malformed envelope", errors.ErrDecryptionFailed)
+	}
+
+	if envelope[1] != algAESGCM {
+		return "", fmt.Errorf("%w: unknown algorithm %d", errors.ErrDecryptionFailed, envelope[1])
+	}
+
+	switch envelope[0] {
+	case envelopeV1:
+		return tryKeys(keys, func(key string) (string, error) { return open(key, envelope, 2) })
+	case envelopeV2:
+		if len(envelope) < 2+keyIDSize {
+			return "", fmt.Errorf("%w: envelope is truncated", errors.ErrDecryptionFailed)
+		}
+
+		id := envelope[2 : 2+keyIDSize]
+		for _, key := range keys {
+			if bytes.Equal(keyID(key), id) {
+				return open(key, envelope, 2+keyIDSize)
+			}
+		}
+		return "", fmt.Errorf("%w: no key with id %x", errors.ErrDecryptionFailed, id)
+	default:
+		return "", fmt.Errorf("%w: unknown envelope version %d", errors.ErrDecryptionFailed, envelope[0])
+	}
+}
+
+//IsEncrypted reports whether message was encrypted by Encrypt or by
+//an earlier version of gitdb. Messages that are not encrypted are JSON objects
+func IsEncrypted(message string) bool {
+	if strings.HasPrefix(message, envelopePrefix) {
+		return true
+	}
+
+	return !strings.HasPrefix(strings.TrimSpace(message), "{")
+}
+
+//tryKeys returns the result of the first key decrypt succeeds with
+func tryKeys(keys []string, decrypt func(key string) (string, error)) (string, error) {
+	var err error
+	for _, key := range keys {
+		var message string
+		if message, err = decrypt(key); err == nil {
+			return message, nil
+		}
+	}
+
+	return "", err
+}
+
+//open decrypts an envelope whose header is headerSize bytes long with key
+func open(key string, envelope []byte, headerSize int) (string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	header, sealed := envelope[:headerSize], envelope[headerSize:]
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", fmt.Errorf("%w: envelope is truncated", errors.ErrDecryptionFailed)
+	}
+
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	message, err := aead.Open(nil, nonce, sealed, header)
 	if err != nil {
-		return ""
+		return "", errors.ErrDecryptionFailed
+	}
+
+	return string(message), nil
+}
+
+func newGCM(key string) (cipher.AEAD, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, err
 	}
 
 	block, err := aes.NewCipher([]byte(key))
 	if err != nil {
-		return ""
+		return nil, err
 	}
 
-	if len(cipherText) < aes.BlockSize {
-		return ""
+	return cipher.NewGCM(block)
+}
+
+//decryptCFB decrypts messages encrypted with AES-CFB by earlier versions of gitdb
+func decryptCFB(key string, secureMessage string) (string, error) {
+	cipherText, err := base64.URLEncoding.DecodeString(secureMessage)
+	if err != nil || len(cipherText) < aes.BlockSize {
+		return "", fmt.Errorf("%w: malformed message", errors.ErrDecryptionFailed)
+	}
+
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return "", errors.ErrEncryptionKey
 	}
 
 	iv := cipherText[:aes.BlockSize]
@@ -58,6 +186,10 @@ func Decrypt(key string, secureMessage string) string {
 	// XORKeyStream can work in-place if the two arguments are the same.
 	stream.XORKeyStream(cipherText, cipherText)
 
-	decodedmess := string(cipherText)
-	return decodedmess
+	//gitdb only encrypts JSON so anything else was decrypted with the wrong key
+	if !json.Valid(cipherText) {
+		return "", errors.ErrDecryptionFailed
+	}
+
+	return string(cipherText), nil
 }
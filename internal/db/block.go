@@ -1,8 +1,9 @@
 package db
 
 import (
-	"bytes"
 	"encoding/json"
+	"fmt"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/errors"
 	"io/ioutil"
 	"os"
@@ -17,7 +18,7 @@ import (
 type Block struct {
 	dataset    *Dataset
 	path       string
-	key        string
+	keys       *crypto.KeyRing
 	size       int64
 	badRecords []string
 	records    map[string]*Record
@@ -29,7 +30,7 @@ type EmptyBlock struct {
 }
 
 //HydrateByPositions should be called on EmptyBlock
-//pos must be []int{offset, position}
+//pos must be []int{offset, length} of a line holding a record in an uncompressed block
 func (b *EmptyBlock) HydrateByPositions(blockFilePath string, positions ...[]int) error {
 	fd, err := os.Open(blockFilePath)
 	if err != nil {
@@ -37,33 +38,24 @@ func (b *EmptyBlock) HydrateByPositions(blockFilePath string, positions ...[]int
 	}
 	defer fd.Close()
 
-	blockJSON := []byte("{")
-	for i, pos := range positions {
+	for _, pos := range positions {
+		if len(pos) != 2 || pos[0] < 0 || pos[1] <= 0 {
+			return fmt.Errorf("invalid position %v in block: %s", pos, blockFilePath)
+		}
 
-		fd.Seek(int64(pos[0]), 0)
 		line := make([]byte, pos[1])
-		fd.Read(line)
-
-		line = bytes.TrimSpace(line)
-		ln := len(line) - 1
-		//are we at the end of seek
-		if i < len(positions)-1 {
-			//ensure line ends with a comma
-			if line[ln] != ',' {
-				line = append(line, ',')
+		if _, err := fd.ReadAt(line, int64(pos[0])); err != nil {
+			return err
+		}
 
-			}
-		} else {
-			//make sure last line has no comma
-			if line[ln] == ',' {
-				line = line[0:ln]
-			}
+		id, data, err := ParseLine(line)
+		if err != nil {
+			return fmt.Errorf("bad record at position %v in block %s: %s", pos, blockFilePath, err)
 		}
-		line = append(line, "\n"...)
-		blockJSON = append(blockJSON, line...)
+		b.Add(id, data)
 	}
-	blockJSON = append(blockJSON, '}')
-	return json.Unmarshal(blockJSON, b)
+
+	return nil
 }
 
 //Hydrate should be called on EmptyBlock
@@ -73,11 +65,39 @@ func (b *EmptyBlock) Hydrate(blockFilePath string) error {
 		return err
 	}
 
-	if err := json.Unmarshal(data, b); err != nil {
-		return err //errBadBlock
+	return b.decode(data)
+}
+
+//CheckBlock returns an error if the block file at blockFilePath cannot be read
+func CheckBlock(blockFilePath string) error {
+	data, err := ioutil.ReadFile(blockFilePath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ParseBlock(data); err != nil {
+		return fmt.Errorf("block %s: %s", blockFilePath, err)
 	}
 
-	return err
+	return nil
+}
+
+//ParseBlock returns the records of block file data written in any Format and Codec
+//without decrypting them. It returns an error if data is not a complete block file
+func ParseBlock(data []byte) (*EmptyBlock, error) {
+	block := NewEmptyBlock(nil)
+	if err := block.decode(data); err != nil {
+		return nil, err
+	}
+
+	//a lines block cut short in the middle of its last line still decodes
+	//but every line of a complete lines block ends with a newline
+	data, _ = decompress(data)
+	if len(data) > 0 && data[0] != '{' && data[len(data)-1] != '\n' {
+		return nil, fmt.Errorf("block is truncated")
+	}
+
+	return block, nil
 }
 
 //Dataset returns the dataset *Block belongs to
@@ -106,25 +126,39 @@ func (b *Block) Path() string {
 }
 
 //NewEmptyBlock should be used to store records from multiple blocks
-func NewEmptyBlock(key string) *EmptyBlock {
+func NewEmptyBlock(keys *crypto.KeyRing) *EmptyBlock {
 	return &EmptyBlock{Block{
-		key:        key,
+		keys:       keys,
 		records:    map[string]*Record{},
 		badRecords: []string{},
 	}}
 }
 
-//LoadBlock loads a block at a particular path
-func LoadBlock(blockFilePath, key string) *Block {
-	block := &Block{
+//NewBlock returns an empty block at a particular path
+func NewBlock(blockFilePath string, keys *crypto.KeyRing) *Block {
+	return &Block{
 		path:       blockFilePath,
-		key:        key,
+		keys:       keys,
 		records:    map[string]*Record{},
 		badRecords: []string{},
 		//TODO figure out a neat way to inject key
-		dataset: &Dataset{path: filepath.Dir(blockFilePath), key: key},
+		dataset: &Dataset{path: filepath.Dir(blockFilePath), keys: keys},
 	}
+}
 
+//ReadBlock loads a block at a particular path and returns an error if it cannot be read
+func ReadBlock(blockFilePath string, keys *crypto.KeyRing) (*Block, error) {
+	block := NewBlock(blockFilePath, keys)
+	if err := block.load(); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+//LoadBlock loads a block at a particular path
+func LoadBlock(blockFilePath string, keys *crypto.KeyRing) *Block {
+	block := NewBlock(blockFilePath, keys)
 	if err := block.load(); err != nil {
 		log.Error(err.Error())
 		block.dataset.badBlocks = append(block.dataset.badBlocks, blockFilePath)
@@ -137,7 +171,7 @@ func LoadBlock(blockFilePath, key string) *Block {
 func (b *Block) MarshalJSON() ([]byte, error) {
 	raw := map[string]string{}
 	for k, v := range b.records {
-		raw[k] = v.data
+		raw[k] = v.stored
 	}
 
 	return json.Marshal(raw)
@@ -168,7 +202,38 @@ func (b *Block) load() error {
 	}
 
 	b.size = int64(len(data))
-	return json.Unmarshal(data, b)
+	return b.decode(data)
+}
+
+//AddFrom adds copies of the records of block with the given ids to b or copies
+//of all records of block if no ids are given. Records of block are not changed
+//when the copies are read so block can be shared e.g by a cache
+func (b *EmptyBlock) AddFrom(block *Block, recordIDs ...string) {
+	if len(recordIDs) == 0 {
+		for recordID, record := range block.records {
+			b.records[recordID] = record.copy()
+		}
+		return
+	}
+
+	for _, recordID := range recordIDs {
+		if record, ok := block.records[recordID]; ok {
+			b.records[recordID] = record.copy()
+		}
+	}
+}
+
+//MemSize returns the approximate number of bytes the records of a block hold in memory
+func (b *Block) MemSize() int64 {
+	var size int64
+	for recordID, record := range b.records {
+		size += int64(len(recordID) + len(record.stored))
+		if record.decrypted {
+			size += int64(len(record.data))
+		}
+	}
+
+	return size
 }
 
 //Record returns record in specifed index i
@@ -186,7 +251,7 @@ func (b *Block) Add(recordID, value string) {
 //Get a record by key from a Block
 func (b *Block) Get(key string) (*Record, error) {
 	if _, ok := b.records[key]; ok {
-		b.records[key].key = b.key
+		b.records[key].keys = b.keys
 		return b.records[key], nil
 	}
 
@@ -203,17 +268,57 @@ func (b *Block) Delete(key string) error {
 	return errors.ErrRecordNotFound
 }
 
+//Reencrypt decrypts the encrypted records and encrypted fields of b with from,
+//encrypts them with to and returns the number of records re-encrypted
+func (b *Block) Reencrypt(from, to *crypto.KeyRing) (int, error) {
+	n := 0
+	for id, record := range b.records {
+		var stored string
+		var err error
+		switch {
+		case crypto.IsEncrypted(record.stored):
+			var data string
+			if data, err = from.Decrypt(record.stored); err == nil {
+				stored, err = to.Encrypt(data)
+			}
+		case hasEncryptedFields(record.stored):
+			stored, err = ReencryptFields(record.stored, from, to)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return n, fmt.Errorf("record %s: %w", id, err)
+		}
+
+		b.records[id] = newRecord(id, stored)
+		n++
+	}
+
+	return n, nil
+}
+
 //Len returns length of block
 func (b *Block) Len() int {
 	return len(b.records)
 }
 
+//RecordIDs returns the ids of the records in a Block without decrypting them
+func (b *Block) RecordIDs() []string {
+	ids := make([]string, 0, len(b.records))
+	for id := range b.records {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 //Records returns decrypted slice of all Records in a Block
 //sorted in asc order of id
 func (b *Block) Records() []*Record {
 	var records []*Record
 	for _, v := range b.records {
-		v.decrypt(b.key)
+		//records that cannot be decrypted return the error when hydrated
+		v.decrypt(b.keys)
 		records = append(records, v)
 	}
 
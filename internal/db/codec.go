@@ -0,0 +1,65 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+//Codec is the compression of a block file
+type Codec string
+
+const (
+	//CodecNone stores a block uncompressed
+	CodecNone Codec = "none"
+	//CodecGzip compresses a block with gzip
+	CodecGzip Codec = "gzip"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+//Valid reports whether c is a known Codec
+func (c Codec) Valid() bool {
+	return c == CodecNone || c == CodecGzip
+}
+
+//Compress compresses data with codec
+func Compress(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone, "":
+		return data, nil
+	case CodecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown block codec: %s", codec)
+}
+
+//Compressed reports whether data is a compressed block file
+func Compressed(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+//decompress returns the content of a block file compressed with any Codec
+func decompress(data []byte) ([]byte, error) {
+	if !Compressed(data) {
+		return data, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
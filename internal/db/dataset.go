@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/digital"
 )
 
@@ -19,22 +20,22 @@ type Dataset struct {
 	badRecords   []string
 	lastModified time.Time
 
-	key string
+	keys *crypto.KeyRing
 }
 
 //LoadDataset loads the dataset at path
-func LoadDataset(datasetPath, key string) *Dataset {
+func LoadDataset(datasetPath string, keys *crypto.KeyRing) *Dataset {
 	ds := &Dataset{
 		path: datasetPath,
-		key:  key,
+		keys: keys,
 	}
 	ds.loadBlocks()
 
 	return ds
 }
 
-//LoadDatasets loads all datasets in given gitdb path
-func LoadDatasets(dbPath, key string) []*Dataset {
+//LoadDatasets loads all datasets in given gitdb path with the keys returned by keys for each dataset
+func LoadDatasets(dbPath string, keys func(dataset string) *crypto.KeyRing) []*Dataset {
 	var datasets []*Dataset
 
 	dirs, err := ioutil.ReadDir(dbPath)
@@ -48,7 +49,7 @@ func LoadDatasets(dbPath, key string) []*Dataset {
 			ds := &Dataset{
 				path:         filepath.Join(dbPath, dir.Name()),
 				lastModified: dir.ModTime(),
-				key:          key,
+				keys:         keys(dir.Name()),
 			}
 
 			datasets = append(datasets, ds)
@@ -163,7 +164,7 @@ func (d *Dataset) loadBlocks() {
 
 	for _, blk := range blks {
 		if !blk.IsDir() && strings.HasSuffix(blk.Name(), ".json") {
-			b := LoadBlock(filepath.Join(d.path, blk.Name()), d.key)
+			b := LoadBlock(filepath.Join(d.path, blk.Name()), d.keys)
 			d.blocks = append(d.blocks, b)
 		}
 	}
@@ -180,7 +181,10 @@ func (d *Dataset) Indexes() []string {
 	}
 
 	for _, indexFile := range indexFiles {
-		indexes = append(indexes, strings.TrimSuffix(indexFile.Name(), ".json"))
+		ext := filepath.Ext(indexFile.Name())
+		if ext == ".idx" || ext == ".json" {
+			indexes = append(indexes, strings.TrimSuffix(indexFile.Name(), ext))
+		}
 	}
 
 	return indexes
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/valyala/fastjson"
+)
+
+//encryptedFieldsKey names the fields of the Data object a record holds encrypted
+const encryptedFieldsKey = "Encrypted"
+
+//EncryptFields encrypts fields of the Data object of record with keys. Each field is
+//replaced by the envelope of its JSON value and the names of the fields encrypted
+//are added to the record so the other fields of the record stay readable
+func EncryptFields(record string, fields []string, keys *crypto.KeyRing) (string, error) {
+	if len(fields) == 0 {
+		return record, nil
+	}
+
+	var p fastjson.Parser
+	v, err := p.Parse(record)
+	if err != nil {
+		return "", err
+	}
+
+	data := v.Get("Data")
+	if data == nil || data.Type() != fastjson.TypeObject {
+		return "", fmt.Errorf("record has no Data object")
+	}
+
+	var a fastjson.Arena
+	names := a.NewArray()
+	n := 0
+	for _, field := range fields {
+		value := data.Get(field)
+		if value == nil {
+			continue
+		}
+
+		envelope, err := keys.Encrypt(string(value.MarshalTo(nil)))
+		if err != nil {
+			return "", err
+		}
+
+		data.Set(field, a.NewString(envelope))
+		names.SetArrayItem(n, a.NewString(field))
+		n++
+	}
+
+	if n == 0 {
+		return record, nil
+	}
+
+	v.Set(encryptedFieldsKey, names)
+	return string(v.MarshalTo(nil)), nil
+}
+
+//DecryptFields returns record with the fields encrypted by EncryptFields decrypted with keys
+func DecryptFields(record string, keys *crypto.KeyRing) (string, error) {
+	data, _, err := decryptFields(record, keys)
+	return data, err
+}
+
+//ReencryptFields decrypts the fields of record encrypted by EncryptFields with from and encrypts them with to
+func ReencryptFields(record string, from, to *crypto.KeyRing) (string, error) {
+	data, fields, err := decryptFields(record, from)
+	if err != nil {
+		return "", err
+	}
+
+	return EncryptFields(data, fields, to)
+}
+
+//decryptFields returns record with its encrypted fields decrypted and the names of the fields
+func decryptFields(record string, keys *crypto.KeyRing) (string, []string, error) {
+	if !hasEncryptedFields(record) {
+		return record, nil, nil
+	}
+
+	var p fastjson.Parser
+	v, err := p.Parse(record)
+	if err != nil {
+		return record, nil, nil
+	}
+
+	names := v.GetArray(encryptedFieldsKey)
+	data := v.Get("Data")
+	if len(names) == 0 || data == nil || data.Type() != fastjson.TypeObject {
+		return record, nil, nil
+	}
+
+	var fields []string
+	for _, name := range names {
+		field := string(name.GetStringBytes())
+		envelope := data.GetStringBytes(field)
+		if envelope == nil {
+			continue
+		}
+
+		plain, err := keys.Decrypt(string(envelope))
+		if err != nil {
+			return record, nil, fmt.Errorf("field %s: %w", field, err)
+		}
+
+		value, err := fastjson.Parse(plain)
+		if err != nil {
+			return record, nil, fmt.Errorf("field %s: %w", field, err)
+		}
+
+		data.Set(field, value)
+		fields = append(fields, field)
+	}
+
+	v.Del(encryptedFieldsKey)
+	return string(v.MarshalTo(nil)), fields, nil
+}
+
+//hasEncryptedFields reports whether record may hold fields encrypted by EncryptFields
+func hasEncryptedFields(record string) bool {
+	return strings.Contains(record, `"`+encryptedFieldsKey+`":[`)
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Format is the encoding of a block file
+type Format string
+
+const (
+	//FormatJSON encodes a block as an indented JSON object of record id => data
+	FormatJSON Format = "json"
+	//FormatLines encodes a block as one id<TAB>data line per record sorted by id
+	FormatLines Format = "lines"
+)
+
+//Valid reports whether f is a known Format
+func (f Format) Valid() bool {
+	return f == FormatJSON || f == FormatLines
+}
+
+//Encode encodes the records of b in format
+func (b *Block) Encode(format Format) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		return json.MarshalIndent(b, "", "\t")
+	case FormatLines:
+		return b.encodeLines()
+	}
+
+	return nil, fmt.Errorf("unknown block format: %s", format)
+}
+
+func (b *Block) encodeLines() ([]byte, error) {
+	ids := make([]string, 0, len(b.records))
+	for id := range b.records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		data := b.records[id].stored
+		if strings.ContainsAny(id, "\t\n") || strings.Contains(data, "\n") {
+			return nil, fmt.Errorf("record %s cannot be written as a line", id)
+		}
+
+		buf.WriteString(id)
+		buf.WriteByte('\t')
+		buf.WriteString(data)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+//decode populates b with the records of a block file written in any Format and Codec
+func (b *Block) decode(data []byte) error {
+	data, err := decompress(data)
+	if err != nil {
+		return err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return json.Unmarshal(data, b)
+	}
+
+	for _, line := range bytes.Split(trimmed, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		id, value, err := ParseLine(line)
+		if err != nil {
+			return err
+		}
+		b.Add(id, value)
+	}
+
+	return nil
+}
+
+//ParseLine returns the record held in a line of a block file of any Format
+func ParseLine(line []byte) (id, data string, err error) {
+	line = bytes.TrimSpace(line)
+	if len(line) > 0 && line[0] == '"' {
+		//line of a FormatJSON block
+		line = bytes.TrimSuffix(line, []byte(","))
+		var raw map[string]string
+		if err := json.Unmarshal(append(append([]byte("{"), line...), '}'), &raw); err != nil {
+			return "", "", err
+		}
+
+		for id, data := range raw {
+			return id, data, nil
+		}
+		return "", "", fmt.Errorf("no record in line: %s", line)
+	}
+
+	i := bytes.IndexByte(line, '\t')
+	if i <= 0 {
+		return "", "", fmt.Errorf("no record in line: %s", line)
+	}
+
+	return string(line[:i]), string(line[i+1:]), nil
+}
+
+//LineID returns the id of the record held in a line of a block file
+//of any Format without decoding the record
+func LineID(line []byte) (string, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) < 2 {
+		return "", false
+	}
+
+	if line[0] != '"' {
+		i := bytes.IndexByte(line, '\t')
+		if i <= 0 {
+			return "", false
+		}
+		return string(line[:i]), true
+	}
+
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			key, err := strconv.Unquote(string(line[:i+1]))
+			return key, err == nil
+		}
+	}
+
+	return "", false
+}
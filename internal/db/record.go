@@ -7,6 +7,7 @@ import (
 
 	"github.com/bouggo/log"
 	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/errors"
 	"github.com/valyala/fastjson"
 )
 
@@ -14,15 +15,24 @@ import (
 type Record struct {
 	id   string
 	data string
-	key  string
+	keys *crypto.KeyRing
+	//stored is data as stored in a block i.e before it is decrypted
+	stored string
 
 	p         fastjson.Parser
 	decrypted bool
+	//err is why data could not be decrypted
+	err error
 }
 
 //newRecord constructs a Record
 func newRecord(id, data string) *Record {
-	return &Record{id: id, data: data}
+	return &Record{id: id, data: data, stored: data}
+}
+
+//copy returns a copy of a record that shares its data but not its parser
+func (r *Record) copy() *Record {
+	return &Record{id: r.id, data: r.data, keys: r.keys, stored: r.stored, decrypted: r.decrypted, err: r.err}
 }
 
 //ID returns record id
@@ -35,9 +45,17 @@ func (r *Record) Data() string {
 	return r.data
 }
 
+//Encrypted reports whether the record or some of its fields are encrypted in its block
+func (r *Record) Encrypted() bool {
+	return crypto.IsEncrypted(r.stored) || hasEncryptedFields(r.stored)
+}
+
 //Hydrate populates given interfacce with underlying record data
 func (r *Record) Hydrate(model interface{}) error {
-	r.decrypt(r.key)
+	if err := r.decrypt(r.keys); err != nil {
+		return err
+	}
+
 	version := r.Version()
 	switch version {
 	case "v1":
@@ -63,21 +81,53 @@ func (r *Record) Hydrate(model interface{}) error {
 	}
 }
 
-func (r *Record) decrypt(key string) {
-	if len(key) > 0 && !r.decrypted {
-		log.Test("decrypting with: " + key)
-		dec := crypto.Decrypt(key, r.data)
-		if len(dec) > 0 {
-			r.data = dec
-		}
+//decrypt replaces encrypted data and encrypted fields with their plain text. Data that
+//cannot be decrypted is kept and the error is returned by every call to decrypt
+func (r *Record) decrypt(keys *crypto.KeyRing) error {
+	if r.decrypted {
+		return r.err
+	}
+
+	encrypted := crypto.IsEncrypted(r.data)
+	if !encrypted && !hasEncryptedFields(r.data) {
 		r.decrypted = true
+		return nil
+	}
+
+	//keys may be given later e.g by Block.Get
+	if keys.Empty() {
+		return fmt.Errorf("record %s: %w", r.id, errors.ErrNoEncryptionKey)
 	}
+
+	r.decrypted = true
+	data := r.data
+	if encrypted {
+		dec, err := keys.Decrypt(data)
+		if err != nil {
+			r.err = fmt.Errorf("record %s: %w", r.id, err)
+			return r.err
+		}
+		data = dec
+	}
+
+	dec, err := DecryptFields(data, keys)
+	if err != nil {
+		r.err = fmt.Errorf("record %s: %w", r.id, err)
+		return r.err
+	}
+
+	r.data = dec
+	return nil
 }
 
 //JSON returns data decrypted and indented
 func (r *Record) JSON() string {
 	var buf bytes.Buffer
-	r.decrypt(r.key)
+	if err := r.decrypt(r.keys); err != nil {
+		log.Error(err.Error())
+		return r.data
+	}
+
 	if err := json.Indent(&buf, []byte(r.data), "", "\t"); err != nil {
 		log.Error(err.Error())
 	}
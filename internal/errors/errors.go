@@ -11,12 +11,18 @@ var (
 	errConnectionInvalid = errors.New("gitDB: connection is not valid. use gitdb.Start to construct a valid connection")
 
 	//external errors
-	ErrNoRecords       = errors.New("gitDB: no records found")
-	ErrRecordNotFound  = errors.New("gitDB: record not found")
-	ErrInvalidRecordID = errors.New("gitDB: invalid record id")
-	ErrDBSyncFailed    = errors.New("gitDB: Database sync failed")
-	ErrLowBattery      = errors.New("gitDB: Insufficient battery power. Syncing disabled")
-	ErrNoOnlineRemote  = errors.New("gitDB: Online remote is not set. Syncing disabled")
-	ErrAccessDenied    = errors.New("gitDB: Access was denied to online repository")
-	ErrInvalidDataset  = errors.New("gitDB: invalid dataset. Dataset not in registry")
+	ErrNoRecords        = errors.New("gitDB: no records found")
+	ErrRecordNotFound   = errors.New("gitDB: record not found")
+	ErrInvalidRecordID  = errors.New("gitDB: invalid record id")
+	ErrDBSyncFailed     = errors.New("gitDB: Database sync failed")
+	ErrLowBattery       = errors.New("gitDB: Insufficient battery power. Syncing disabled")
+	ErrNoOnlineRemote   = errors.New("gitDB: Online remote is not set. Syncing disabled")
+	ErrAccessDenied     = errors.New("gitDB: Access was denied to online repository")
+	ErrInvalidDataset   = errors.New("gitDB: invalid dataset. Dataset not in registry")
+	ErrUniqueViolation  = errors.New("gitDB: unique index violation")
+	ErrIndexBuilding    = errors.New("gitDB: index is being built")
+	ErrStopIteration    = errors.New("gitDB: stop iteration")
+	ErrNoEncryptionKey  = errors.New("gitDB: data is encrypted but no encryption key is set")
+	ErrEncryptionKey    = errors.New("gitDB: encryption key must be 16, 24 or 32 bytes long")
+	ErrDecryptionFailed = errors.New("gitDB: decryption failed - wrong key or tampered data")
 )
@@ -0,0 +1,177 @@
+package gitdb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+//KeyProvider provides the encryption key of a connection so that keys do not have to
+//be kept in Config. Open asks the provider for the key once and encrypts with it instead of Config.EncryptionKey
+type KeyProvider interface {
+	Key() (string, error)
+}
+
+//scrypt parameters recommended for interactive logins
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+type staticKey string
+
+//StaticKey provides key as is
+func StaticKey(key string) KeyProvider {
+	return staticKey(key)
+}
+
+func (k staticKey) Key() (string, error) {
+	return string(k), nil
+}
+
+type envKey string
+
+//EnvKey provides the key held by the environment variable name
+func EnvKey(name string) KeyProvider {
+	return envKey(name)
+}
+
+func (k envKey) Key() (string, error) {
+	key, ok := os.LookupEnv(string(k))
+	if !ok {
+		return "", fmt.Errorf("gitDB: environment variable %s is not set", string(k))
+	}
+
+	return key, nil
+}
+
+type fileKey string
+
+//FileKey provides the key held by the file at path. A trailing newline is not part of the key
+func FileKey(path string) KeyProvider {
+	return fileKey(path)
+}
+
+func (k fileKey) Key() (string, error) {
+	b, err := ioutil.ReadFile(string(k))
+	if err != nil {
+		return "", fmt.Errorf("gitDB: failed to read key file: %w", err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+type passphraseKey struct {
+	passphrase string
+}
+
+//PassphraseKey provides a 32 byte key derived from passphrase with scrypt. The salt is
+//generated when the database is first opened with a passphrase and committed to the
+//database so every client derives the same key. It only works as Config.KeyProvider
+func PassphraseKey(passphrase string) KeyProvider {
+	return &passphraseKey{passphrase: passphrase}
+}
+
+func (k *passphraseKey) Key() (string, error) {
+	return "", errors.New("gitDB: PassphraseKey needs the salt of a database, set it as Config.KeyProvider")
+}
+
+func (k *passphraseKey) dbKey(dbPath string) (string, error) {
+	return DerivePassphraseKey(dbPath, k.passphrase)
+}
+
+//dbKeyProvider is a KeyProvider whose key is derived from data kept in the database at dbPath
+type dbKeyProvider interface {
+	dbKey(dbPath string) (string, error)
+}
+
+//DerivePassphraseKey returns the key PassphraseKey derives from passphrase for the database
+//at dbPath e.g to rotate the key of a database from one passphrase to another with RotateKey
+func DerivePassphraseKey(dbPath, passphrase string) (string, error) {
+	if len(passphrase) == 0 {
+		return "", ErrNoEncryptionKey
+	}
+
+	b, err := ioutil.ReadFile(saltFilePath(dbPath))
+	if err != nil {
+		return "", fmt.Errorf("gitDB: failed to read salt of passphrase: %w", err)
+	}
+
+	salt, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(salt) == 0 {
+		return "", errors.New("gitDB: invalid salt of passphrase")
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return "", err
+	}
+
+	return string(key), nil
+}
+
+//saltFilePath returns the path of the file holding the salt of PassphraseKey in the database at dbPath
+func saltFilePath(dbPath string) string {
+	return filepath.Join(dbPath, "data", ".gitdb-salt")
+}
+
+//createSalt generates the salt of PassphraseKey and commits it
+//if Config.KeyProvider is a PassphraseKey and the database has no salt yet
+func (g *gitdb) createSalt() error {
+	if _, ok := g.config.KeyProvider.(*passphraseKey); !ok {
+		return nil
+	}
+
+	saltFile := saltFilePath(g.absDbPath())
+	if _, err := os.Stat(saltFile); !os.IsNotExist(err) {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(saltFile, []byte(hex.EncodeToString(salt)+"\n"), 0644); err != nil {
+		return err
+	}
+
+	return g.driver.commit(saltFile, "gitDB: add salt of passphrase key", g.config.User)
+}
+
+//resolveKey makes the key of Config.KeyProvider the encryption key of the connection.
+//The key is only kept in the key ring of the connection so Config never holds it
+func (g *gitdb) resolveKey() error {
+	provider := g.config.KeyProvider
+	if provider == nil {
+		return nil
+	}
+
+	var key string
+	var err error
+	if p, ok := provider.(dbKeyProvider); ok {
+		key, err = p.dbKey(g.absDbPath())
+	} else {
+		key, err = provider.Key()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := crypto.ValidKey(key); err != nil {
+		return err
+	}
+
+	g.keys = crypto.NewKeyRing(key, g.config.KeyRing...)
+	return nil
+}
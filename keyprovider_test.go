@@ -0,0 +1,144 @@
+package gitdb_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+func TestKeyProviders(t *testing.T) {
+	key := getConfig().EncryptionKey
+
+	keyFile := filepath.Join(os.TempDir(), "gitdb-test.key")
+	if err := ioutil.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyFile)
+
+	os.Setenv("GITDB_TEST_KEY", key)
+	defer os.Unsetenv("GITDB_TEST_KEY")
+
+	providers := map[string]gitdb.KeyProvider{
+		"StaticKey": gitdb.StaticKey(key),
+		"EnvKey":    gitdb.EnvKey("GITDB_TEST_KEY"),
+		"FileKey":   gitdb.FileKey(keyFile),
+	}
+
+	for name, provider := range providers {
+		got, err := provider.Key()
+		if err != nil || got != key {
+			t.Errorf("%s want: %s, got: %s (%v)", name, key, got, err)
+		}
+	}
+
+	if _, err := gitdb.EnvKey("GITDB_TEST_NO_KEY").Key(); err == nil {
+		t.Error("EnvKey should fail if the environment variable is not set")
+	}
+
+	if _, err := gitdb.FileKey(keyFile + ".missing").Key(); err == nil {
+		t.Error("FileKey should fail if the file does not exist")
+	}
+}
+
+func TestPassphraseKey(t *testing.T) {
+	cfg := getConfig()
+	cfg.EncryptionKey = ""
+	cfg.KeyProvider = gitdb.PassphraseKey("correct horse battery staple")
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	if _, err := gitdb.PassphraseKey("correct horse battery staple").Key(); err == nil {
+		t.Error("PassphraseKey should only provide a key as Config.KeyProvider")
+	}
+
+	//the salt is committed so that every client derives the same key
+	out, err := exec.Command("git", "-C", filepath.Join(dbPath, "data"), "log", "--oneline", "--", ".gitdb-salt").CombinedOutput()
+	if err != nil || len(out) == 0 {
+		t.Errorf("salt of passphrase should be committed (%v): %s", err, out)
+	}
+
+	key1, err := gitdb.DerivePassphraseKey(dbPath, "correct horse battery staple")
+	if err != nil || len(key1) != 32 {
+		t.Fatalf("gitdb.DerivePassphraseKey want: 32 byte key, got: %d bytes (%v)", len(key1), err)
+	}
+
+	key2, _ := gitdb.DerivePassphraseKey(dbPath, "correct horse battery staple")
+	if key1 != key2 {
+		t.Error("gitdb.DerivePassphraseKey should derive the same key from the same passphrase")
+	}
+
+	key3, _ := gitdb.DerivePassphraseKey(dbPath, "another passphrase")
+	if key3 == key1 {
+		t.Error("gitdb.DerivePassphraseKey should derive another key from another passphrase")
+	}
+
+	if _, err := gitdb.DerivePassphraseKey(dbPath, ""); !errors.Is(err, gitdb.ErrNoEncryptionKey) {
+		t.Errorf("gitdb.DerivePassphraseKey want: %s, got: %v", gitdb.ErrNoEncryptionKey, err)
+	}
+
+	//the derived key is not part of the config of the connection
+	if c := testDb.Config(); len(c.EncryptionKey) > 0 || len(c.KeyRing) > 0 {
+		t.Error("testDb.Config should not hold the key of Config.KeyProvider")
+	}
+
+	if err := testDb.RotateKey(key1, key3); err != nil {
+		t.Fatalf("testDb.RotateKey failed: %s", err)
+	}
+
+	if c := testDb.Config(); len(c.EncryptionKey) > 0 || len(c.KeyRing) > 0 {
+		t.Error("testDb.Config should not hold keys rotated from Config.KeyProvider")
+	}
+}
+
+func TestOpenWithKeyProvider(t *testing.T) {
+	cfg := getConfig()
+	cfg.EncryptionKey = ""
+	cfg.KeyProvider = gitdb.PassphraseKey("secret passphrase")
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	m := getTestMessageWithId(1)
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//the same passphrase unlocks the database again
+	testDb.Close()
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Get(gitdb.ID(m), &Message{}); err != nil {
+		t.Errorf("testDb.Get failed: %s", err)
+	}
+
+	//another passphrase does not
+	testDb.Close()
+	cfg.KeyProvider = gitdb.PassphraseKey("wrong passphrase")
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Get(gitdb.ID(m), &Message{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+
+	//a provider that fails makes Open fail
+	cfg.KeyProvider = gitdb.EnvKey("GITDB_TEST_NO_KEY")
+	if _, err := gitdb.Open(cfg); err == nil {
+		t.Error("gitdb.Open should fail if the key provider fails")
+	}
+
+	cfg.KeyProvider = gitdb.StaticKey("short")
+	if _, err := gitdb.Open(cfg); !errors.Is(err, gitdb.ErrEncryptionKey) {
+		t.Errorf("gitdb.Open want: %s, got: %v", gitdb.ErrEncryptionKey, err)
+	}
+
+	cfg.EncryptionKey = getConfig().EncryptionKey
+	if err := cfg.Validate(); err == nil {
+		t.Error("cfg.Validate should fail if EncryptionKey and KeyProvider are both set")
+	}
+}
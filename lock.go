@@ -1,8 +1,8 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,6 +11,16 @@ import (
 )
 
 func (g *gitdb) Lock(mo Model) error {
+	return g.LockContext(context.Background(), mo)
+}
+
+//LockContext writes the lock files of mo unless ctx is done first
+func (g *gitdb) LockContext(ctx context.Context, mo Model) error {
+	unlock, err := g.lockConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	m := wrap(mo)
 	if _, ok := mo.(LockableModel); !ok {
@@ -30,7 +40,7 @@ func (g *gitdb) Lock(mo Model) error {
 	lockFiles := mo.(LockableModel).GetLockFileNames()
 	for _, file := range lockFiles {
 		lockFile := filepath.Join(fullPath, file+".lock")
-		g.events <- newWriteBeforeEvent("...", lockFile)
+		g.notify(ctx, newWriteBeforeEvent("...", lockFile))
 
 		//when locking a model, lockfile should not exist
 		if _, err := os.Stat(lockFile); err == nil {
@@ -40,7 +50,7 @@ func (g *gitdb) Lock(mo Model) error {
 			return errors.New("Lock file already exist: " + lockFile)
 		}
 
-		err := ioutil.WriteFile(lockFile, []byte(""), 0644)
+		err := writeFileAtomic(lockFile, []byte(""), 0644)
 		if err != nil {
 			if derr := g.deleteLockFiles(lockFilesWritten); derr != nil {
 				log.Error(derr.Error())
@@ -51,16 +61,23 @@ func (g *gitdb) Lock(mo Model) error {
 		lockFilesWritten = append(lockFilesWritten, lockFile)
 	}
 
-	g.commit.Add(1)
 	commitMsg := "Created Lock Files for: " + ID(m)
-	g.events <- newWriteEvent(commitMsg, fullPath, g.autoCommit)
 
 	//block here until write has been committed
-	g.waitForCommit()
-	return nil
+	return g.commitEvent(ctx, newWriteEvent(commitMsg, fullPath, g.autoCommit))
 }
 
 func (g *gitdb) Unlock(mo Model) error {
+	return g.UnlockContext(context.Background(), mo)
+}
+
+//UnlockContext removes the lock files of mo unless ctx is done first
+func (g *gitdb) UnlockContext(ctx context.Context, mo Model) error {
+	unlock, err := g.lockConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	m := wrap(mo)
 	if _, ok := mo.(LockableModel); !ok {
@@ -82,13 +99,10 @@ func (g *gitdb) Unlock(mo Model) error {
 		}
 	}
 
-	g.commit.Add(1)
 	commitMsg := "Removing Lock Files for: " + ID(m)
-	g.events <- newWriteEvent(commitMsg, fullPath, g.autoCommit)
 
 	//block here until write has been committed
-	g.waitForCommit()
-	return nil
+	return g.commitEvent(ctx, newWriteEvent(commitMsg, fullPath, g.autoCommit))
 }
 
 func (g *gitdb) deleteLockFiles(files []string) error {
@@ -15,12 +15,17 @@ func newMail(subject string, body string) *mail {
 }
 
 func (g *gitdb) GetMails() []*mail {
+	g.mailMu.Lock()
+	defer g.mailMu.Unlock()
+
 	mails := g.mails
 	g.mails = []*mail{}
 	return mails
 }
 
 func (g *gitdb) sendMail(m *mail) {
-	g.mails = append(g.mails, m)
+	g.mailMu.Lock()
+	defer g.mailMu.Unlock()
 
+	g.mails = append(g.mails, m)
 }
@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"reflect"
 	"time"
 )
 
@@ -67,6 +68,9 @@ func (m *model) BeforeInsert() error {
 }
 
 func (g *gitdb) RegisterModel(dataset string, m Model) bool {
+	g.regMu.Lock()
+	defer g.regMu.Unlock()
+
 	if g.registry == nil {
 		g.registry = make(map[string]Model)
 	}
@@ -75,7 +79,10 @@ func (g *gitdb) RegisterModel(dataset string, m Model) bool {
 }
 
 func (g *gitdb) isRegistered(dataset string) bool {
-	if _, ok := g.registry[dataset]; ok {
+	g.regMu.RLock()
+	_, ok := g.registry[dataset]
+	g.regMu.RUnlock()
+	if ok {
 		return true
 	}
 
@@ -85,3 +92,33 @@ func (g *gitdb) isRegistered(dataset string) bool {
 
 	return false
 }
+
+//newInstance returns a new Model of the type of m so that records can be hydrated
+//without writing to m, which is shared by every goroutine using the registry
+func newInstance(m Model) Model {
+	t := reflect.TypeOf(m)
+	if t.Kind() != reflect.Ptr {
+		return m
+	}
+
+	if n, ok := reflect.New(t.Elem()).Interface().(Model); ok {
+		return n
+	}
+	return m
+}
+
+//modelFor returns the Model registered for a dataset
+func (g *gitdb) modelFor(dataset string) Model {
+	g.regMu.RLock()
+	m, ok := g.registry[dataset]
+	g.regMu.RUnlock()
+	if ok {
+		return m
+	}
+
+	if g.config.Factory != nil {
+		return g.config.Factory(dataset)
+	}
+
+	return nil
+}
@@ -38,6 +38,32 @@ func (g *gitdb) indexPath(dataset string) string {
 	return filepath.Join(g.indexDir(), dataset)
 }
 
+func (g *gitdb) textIndexFilePath(dataset string) string {
+	return filepath.Join(g.indexPath(dataset), "text.fts")
+}
+
+func (g *gitdb) indexFilePath(dataset, index string) string {
+	return filepath.Join(g.indexPath(dataset), index+".idx")
+}
+
+//positions path
+func (g *gitdb) positionsDir() string {
+	return filepath.Join(g.absDbPath(), g.internalDirName(), "positions")
+}
+
+func (g *gitdb) positionsFilePath(blockFile string) string {
+	rel, err := filepath.Rel(g.dbDir(), blockFile)
+	if err != nil {
+		rel = filepath.Base(blockFile)
+	}
+	return filepath.Join(g.positionsDir(), rel)
+}
+
+//quarantine path
+func (g *gitdb) quarantineDir() string {
+	return filepath.Join(g.absDbPath(), g.internalDirName(), "quarantine")
+}
+
 //ssh paths
 func (g *gitdb) sshDir() string {
 	return filepath.Join(g.absDbPath(), g.internalDirName(), "ssh")
@@ -0,0 +1,231 @@
+package gitdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//gdbBlockPositions holds the position of every record in a block file.
+//Size and ModTime identify the version of the block file the positions
+//were extracted from so stale positions are never used.
+//Records of a compressed block file have no positions. Checksum is the checksum
+//of the block file when gitdb last changed it so Verify can tell if it was changed since
+type gdbBlockPositions struct {
+	Size       int64    `json:"s"`
+	ModTime    int64    `json:"m"`
+	Compressed bool     `json:"c,omitempty"`
+	Checksum   string   `json:"h,omitempty"`
+	Records    gdbIndex `json:"r"`
+}
+
+//checksum returns the hex encoded SHA-256 of the content of a block file
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+//fresh reports whether p was extracted from the block file described by info
+func (p *gdbBlockPositions) fresh(info os.FileInfo) bool {
+	return p.Size == info.Size() && p.ModTime == info.ModTime().UnixNano()
+}
+
+//blockPositions returns the positions of records in blockFile or nil
+//if no positions are known or the block file has changed since they were extracted
+func (g *gitdb) blockPositions(blockFile string) *gdbBlockPositions {
+	info, err := os.Stat(blockFile)
+	if err != nil {
+		return nil
+	}
+
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	if g.positionCache == nil {
+		g.positionCache = make(gdbIndexCache)
+	}
+
+	positions, ok := g.positionCache[blockFile]
+	if !ok {
+		positions = g.readPositions(blockFile)
+		g.positionCache[blockFile] = positions
+	}
+
+	if positions == nil || !positions.fresh(info) {
+		return nil
+	}
+
+	return positions
+}
+
+func (g *gitdb) readPositions(blockFile string) *gdbBlockPositions {
+	data, err := ioutil.ReadFile(g.positionsFilePath(blockFile))
+	if err != nil {
+		return nil
+	}
+
+	positions := &gdbBlockPositions{}
+	if err := json.Unmarshal(data, positions); err != nil {
+		log.Error(err.Error())
+		return nil
+	}
+
+	return positions
+}
+
+//updatePositions extracts the positions of all records in blockFile and saves them.
+//The stored checksum is kept as blockFile was not changed by gitdb
+func (g *gitdb) updatePositions(blockFile string) {
+	//nothing to extract from a compressed block
+	if positions := g.blockPositions(blockFile); positions != nil && positions.Compressed {
+		return
+	}
+
+	data, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	g.savePositions(blockFile, data, g.blockChecksum(blockFile))
+}
+
+//blockChecksum returns the checksum of blockFile stored when gitdb last changed it or ""
+func (g *gitdb) blockChecksum(blockFile string) string {
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	positions, ok := g.positionCache[blockFile]
+	if !ok {
+		positions = g.readPositions(blockFile)
+	}
+
+	if positions == nil {
+		return ""
+	}
+	return positions.Checksum
+}
+
+//refreshChecksums stores the checksums of block files gitdb changed through git e.g
+//by merging fetched changes or reverting a transaction. Removed block files are skipped
+func (g *gitdb) refreshChecksums(blockFiles ...string) {
+	for _, blockFile := range blockFiles {
+		data, err := ioutil.ReadFile(blockFile)
+		if err != nil {
+			continue
+		}
+		g.savePositions(blockFile, data, checksum(data))
+	}
+}
+
+//savePositions extracts the positions of all records from data
+//which must be the current content of blockFile and saves them with sum
+func (g *gitdb) savePositions(blockFile string, data []byte, sum string) {
+	info, err := os.Stat(blockFile)
+	if err != nil {
+		return
+	}
+
+	positions := &gdbBlockPositions{
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UnixNano(),
+		Compressed: db.Compressed(data),
+		Checksum:   sum,
+		Records:    gdbIndex{},
+	}
+
+	if !positions.Compressed {
+		positions.Records = extractPositions(data)
+	}
+
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	if g.positionCache == nil {
+		g.positionCache = make(gdbIndexCache)
+	}
+	g.positionCache[blockFile] = positions
+
+	positionsFile := g.positionsFilePath(blockFile)
+	if err := os.MkdirAll(filepath.Dir(positionsFile), 0755); err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	b, err := json.Marshal(positions)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if err := writeFileAtomic(positionsFile, b, 0644); err != nil {
+		log.Error("Failed to write positions: " + positionsFile)
+	}
+}
+
+//hydrateByPositions reads only the records in recordIDs from blockFile into dataBlock.
+//It returns false if positions are unknown or stale so the caller can fall back
+//to reading the full block
+func (g *gitdb) hydrateByPositions(dataBlock *db.EmptyBlock, blockFile string, recordIDs []string) bool {
+	positions := g.blockPositions(blockFile)
+	if positions == nil || positions.Compressed {
+		return false
+	}
+
+	var pos [][]int
+	for _, recordID := range recordIDs {
+		p, ok := positions.Records[recordID]
+		if !ok {
+			return false
+		}
+		pos = append(pos, []int{p.Offset, p.Len})
+	}
+
+	found := db.NewEmptyBlock(g.keysForFile(blockFile))
+	if err := found.HydrateByPositions(blockFile, pos...); err != nil {
+		log.Error(err.Error())
+		return false
+	}
+
+	//positions must point at the requested records
+	for _, recordID := range recordIDs {
+		record, err := found.Get(recordID)
+		if err != nil {
+			log.Info("stale positions for block: " + blockFile)
+			return false
+		}
+		dataBlock.Add(recordID, record.Data())
+	}
+
+	return true
+}
+
+//extractPositions returns the position of all records in block file data.
+//Each record in a block file is on its own line so a record's position
+//is the offset and length of its line
+func extractPositions(data []byte) gdbIndex {
+	positions := gdbIndex{}
+
+	offset := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if recordID, ok := db.LineID(line); ok {
+			positions[recordID] = gdbIndexValue{Offset: offset, Len: len(line)}
+		}
+
+		//account for newline
+		offset += len(line) + 1
+	}
+
+	return positions
+}
@@ -0,0 +1,355 @@
+package gitdb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+type queryOp int
+
+const (
+	opWhere queryOp = iota
+	opAnd
+	opOr
+	opNot
+)
+
+//SortOrder defines the order in which Find returns records
+type SortOrder int
+
+const (
+	//SortAsc returns records in ascending order
+	SortAsc SortOrder = iota
+	//SortDesc returns records in descending order
+	SortDesc
+)
+
+//Query represents a composable search against GitDB indexes.
+//Queries are built with Where, Between, And, Or and Not
+type Query struct {
+	op      queryOp
+	param   *SearchParam
+	mode    SearchMode
+	clauses []*Query
+
+	//paging options only apply to the Query passed to Find
+	orderBy string
+	order   SortOrder
+	limit   int
+	offset  int
+	after   string
+}
+
+//Page is a page of records returned by FindPage
+type Page struct {
+	Records []*db.Record
+	//Next is the cursor to pass to Query.After for the next page.
+	//It is empty if there are no records after the page
+	Next string
+}
+
+//cursorPrefix marks a cursor returned in Page.Next
+const cursorPrefix = "cursor:"
+
+//orderKey is the position of a record in the order of a query: its order value and its id
+type orderKey struct {
+	Value    interface{} `json:"v,omitempty"`
+	HasValue bool        `json:"h,omitempty"`
+	ID       string      `json:"id"`
+}
+
+//cursor encodes k as a cursor for Query.After
+func (k orderKey) cursor() string {
+	b, _ := json.Marshal(k)
+	return cursorPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+//parseCursor decodes a cursor returned by orderKey.cursor
+func parseCursor(cursor string) (orderKey, error) {
+	var k orderKey
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(cursor, cursorPrefix))
+	if err == nil {
+		err = json.Unmarshal(b, &k)
+	}
+	if err != nil || len(k.ID) == 0 {
+		return k, errors.New("gitDB: invalid query cursor")
+	}
+	return k, nil
+}
+
+//Where constructs a Query that matches records whose index value satisfies mode
+func Where(index string, mode SearchMode, value string) *Query {
+	return &Query{op: opWhere, param: &SearchParam{Index: index, Value: value}, mode: mode}
+}
+
+//Between constructs a Query that matches records whose index value is between from and to (inclusive)
+func Between(index string, from, to string) *Query {
+	return &Query{op: opWhere, param: &SearchParam{Index: index, Value: from, To: to}, mode: SearchBetween}
+}
+
+//And constructs a Query that matches records matched by all queries.
+//And with no queries matches every record in a dataset
+func And(queries ...*Query) *Query {
+	return &Query{op: opAnd, clauses: queries}
+}
+
+//Or constructs a Query that matches records matched by any of the queries
+func Or(queries ...*Query) *Query {
+	return &Query{op: opOr, clauses: queries}
+}
+
+//Not constructs a Query that matches records not matched by query
+func Not(query *Query) *Query {
+	return &Query{op: opNot, clauses: []*Query{query}}
+}
+
+//All constructs a Query that matches every record in a dataset.
+//Use it with OrderBy, Limit and Offset to page through a dataset
+func All() *Query {
+	return And()
+}
+
+//And returns a Query that matches records matched by q and all queries
+func (q *Query) And(queries ...*Query) *Query {
+	return And(append([]*Query{q}, queries...)...)
+}
+
+//Or returns a Query that matches records matched by q or any of the queries
+func (q *Query) Or(queries ...*Query) *Query {
+	return Or(append([]*Query{q}, queries...)...)
+}
+
+//OrderBy sorts the records returned by Find by the value of index.
+//Records with the same value are sorted by id
+func (q *Query) OrderBy(index string, order SortOrder) *Query {
+	q.orderBy = index
+	q.order = order
+	return q
+}
+
+//Limit sets the maximum number of records returned by Find
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+//Offset sets the number of matching records Find skips before returning records
+func (q *Query) Offset(n int) *Query {
+	q.offset = n
+	return q
+}
+
+//After makes Find return only records that come after cursor in the order of the query.
+//Use Page.Next returned by FindPage as the cursor to the next page. It holds the order value
+//and id of the last record of the page so that no records are skipped or repeated if that record
+//changes or is deleted. A record id is also accepted, in which case its current order value is used
+func (q *Query) After(cursor string) *Query {
+	q.after = cursor
+	return q
+}
+
+//paged reports whether q orders or limits its results
+func (q *Query) paged() bool {
+	return len(q.orderBy) > 0 || q.limit > 0 || q.offset > 0 || len(q.after) > 0
+}
+
+//searchQuery converts SearchParams into a Query as used by *gitdb.Search
+func searchQuery(searchParams []*SearchParam, searchMode SearchMode) *Query {
+	q := &Query{op: opOr}
+	for _, sp := range searchParams {
+		q.clauses = append(q.clauses, &Query{op: opWhere, param: sp, mode: searchMode})
+	}
+	return q
+}
+
+//indexes returns the names of all indexes used by q
+func (q *Query) indexes() []string {
+	var names []string
+	if q.op == opWhere {
+		return append(names, q.param.Index)
+	}
+
+	for _, c := range q.clauses {
+		names = append(names, c.indexes()...)
+	}
+	return names
+}
+
+//validate ensures q and all its clauses are well formed
+func (q *Query) validate() error {
+	if q == nil {
+		return errors.New("gitDB: nil query")
+	}
+
+	if q.limit < 0 || q.offset < 0 {
+		return errors.New("gitDB: query limit and offset must not be negative")
+	}
+
+	if strings.HasPrefix(q.after, cursorPrefix) {
+		if _, err := parseCursor(q.after); err != nil {
+			return err
+		}
+	}
+
+	switch q.op {
+	case opWhere:
+		if q.param == nil || len(q.param.Index) == 0 {
+			return errors.New("gitDB: query index must be set")
+		}
+		if q.mode < SearchEquals || q.mode > SearchNotEquals {
+			return fmt.Errorf("gitDB: invalid search mode %d", q.mode)
+		}
+	case opNot:
+		if len(q.clauses) != 1 {
+			return errors.New("gitDB: Not requires exactly one query")
+		}
+	}
+
+	for _, c := range q.clauses {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//eval returns the ids of all records matched by q. index is used to look up
+//an index by name and index("id") must return all records in the dataset
+func (q *Query) eval(index func(name string) gdbSimpleIndex) map[string]string {
+	matches := map[string]string{}
+
+	switch q.op {
+	case opWhere:
+		for recordID, iv := range index(q.param.Index) {
+			if q.param.match(iv, q.mode) {
+				matches[recordID] = recordID
+			}
+		}
+	case opOr:
+		for _, c := range q.clauses {
+			for recordID := range c.eval(index) {
+				matches[recordID] = recordID
+			}
+		}
+	case opAnd:
+		for recordID := range index("id") {
+			matches[recordID] = recordID
+		}
+		for _, c := range q.clauses {
+			if len(matches) == 0 {
+				break
+			}
+			cm := c.eval(index)
+			for recordID := range matches {
+				if _, ok := cm[recordID]; !ok {
+					delete(matches, recordID)
+				}
+			}
+		}
+	case opNot:
+		cm := q.clauses[0].eval(index)
+		for recordID := range index("id") {
+			if _, ok := cm[recordID]; !ok {
+				matches[recordID] = recordID
+			}
+		}
+	}
+
+	return matches
+}
+
+//paginate sorts matches in the order of q and returns the ids of the requested page
+//and the cursor to the next page, which is empty if there are no records after the page
+func (q *Query) paginate(matches map[string]string, index func(name string) gdbSimpleIndex) ([]string, string) {
+	var values gdbSimpleIndex
+	if len(q.orderBy) > 0 {
+		values = index(q.orderBy)
+	}
+
+	key := func(recordID string) orderKey {
+		value, ok := values[recordID]
+		return orderKey{Value: value, HasValue: ok, ID: recordID}
+	}
+
+	ids := make([]string, 0, len(matches))
+	for recordID := range matches {
+		ids = append(ids, recordID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return q.less(key(ids[i]), key(ids[j])) })
+
+	start := 0
+	if len(q.after) > 0 {
+		after := key(q.after)
+		if strings.HasPrefix(q.after, cursorPrefix) {
+			after, _ = parseCursor(q.after)
+		}
+		start = sort.Search(len(ids), func(i int) bool { return q.less(after, key(ids[i])) })
+	}
+
+	start += q.offset
+	if start > len(ids) {
+		start = len(ids)
+	}
+	ids = ids[start:]
+
+	next := ""
+	if q.limit > 0 && q.limit < len(ids) {
+		ids = ids[:q.limit]
+		next = key(ids[len(ids)-1]).cursor()
+	}
+
+	return ids, next
+}
+
+//less reports whether a comes before b in the order of q.
+//Records with the same order value are ordered by id
+func (q *Query) less(a, b orderKey) bool {
+	c := compareOrderValues(a, b)
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+
+	if q.order == SortDesc {
+		return c > 0
+	}
+	return c < 0
+}
+
+//compareOrderValues compares the order values of a and b.
+//records without an order value sort after records with one
+func compareOrderValues(a, b orderKey) int {
+	switch {
+	case !a.HasValue && !b.HasValue:
+		return 0
+	case !a.HasValue:
+		return 1
+	case !b.HasValue:
+		return -1
+	}
+
+	return compareIndexValues(a.Value, b.Value)
+}
+
+//orderRecords returns records in the order of ids
+func orderRecords(records []*db.Record, ids []string) []*db.Record {
+	byID := make(map[string]*db.Record, len(records))
+	for _, record := range records {
+		byID[record.ID()] = record
+	}
+
+	ordered := make([]*db.Record, 0, len(ids))
+	for _, id := range ids {
+		if record, ok := byID[id]; ok {
+			ordered = append(ordered, record)
+		}
+	}
+
+	return ordered
+}
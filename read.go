@@ -1,30 +1,19 @@
 package gitdb
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/bouggo/log"
 	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
-func (g *gitdb) loadBlock(blockFile string) (*db.Block, error) {
-
-	if g.loadedBlocks == nil {
-		g.loadedBlocks = map[string]*db.Block{}
-	}
-
-	//if block file is not cached, load into cache
-	if _, ok := g.loadedBlocks[blockFile]; !ok {
-		g.loadedBlocks[blockFile] = db.LoadBlock(blockFile, g.config.EncryptionKey)
-	}
-
-	return g.loadedBlocks[blockFile], nil
-}
-
 func (g *gitdb) doGet(id string) (*db.Record, error) {
 
 	dataset, block, _, err := ParseID(id)
@@ -36,62 +25,65 @@ func (g *gitdb) doGet(id string) (*db.Record, error) {
 		return nil, ErrInvalidDataset
 	}
 
+	if resolved := g.resolveID(id); resolved != id {
+		id = resolved
+		_, block, _, _ = ParseID(id)
+	}
+
 	blockFilePath := filepath.Join(g.dbDir(), dataset, block+".json")
-	if _, err := os.Stat(blockFilePath); err != nil {
+	info, err := os.Stat(blockFilePath)
+	if err != nil {
 		return nil, ErrNoRecords
 	}
 
-	//we used to to a doGetByIndex here but it doesn't work properly
-	//TODO revisit doGetByIndex
-	dataBlock := db.NewEmptyBlock(g.config.EncryptionKey)
-	if err := dataBlock.Hydrate(blockFilePath); err != nil {
+	dataBlock := db.NewEmptyBlock(g.keysFor(dataset))
+	if cached := g.blockCache.get(blockFilePath, info); cached != nil {
+		dataBlock.AddFrom(cached, id)
+		return dataBlock.Get(id)
+	}
+
+	//read only the record if its position in the block is known
+	if g.hydrateByPositions(dataBlock, blockFilePath, []string{id}) {
+		return dataBlock.Get(id)
+	}
+
+	cached, err := g.fillBlock(blockFilePath, info)
+	if err != nil {
 		return nil, err
 	}
+	g.updatePositions(blockFilePath)
 
+	dataBlock.AddFrom(cached, id)
 	return dataBlock.Get(id)
 }
 
-//func (g *gitdb) doGetByIndex(id, dataset string) (*db.Record, error) {
-//	//read id index
-//	indexFile := filepath.Join(g.indexDir(), dataset, "id.json")
-//	if _, ok := g.indexCache[indexFile]; !ok {
-//		g.buildIndexTargeted(dataset)
-//	}
-//
-//	iv, ok := g.indexCache[indexFile][id]
-//	if ok {
-//		dataBlock := db.NewEmptyBlock(g.config.EncryptionKey)
-//		err = dataBlock.HydrateByPositions(blockFilePath, []int{iv.Offset, iv.Len})
-//		if err != nil {
-//			log.Error(err.Error())
-//			return nil, fmt.Errorf("Record %s not found in %s", id, dataset)
-//		}
-//
-//		record, err := dataBlock.Get(id)
-//		if err != nil {
-//			log.Error(err.Error())
-//			return nil, fmt.Errorf("Record %s not found in %s", id, dataset)
-//		}
-//
-//		return record, nil
-//	}
-//
-//	return nil, fmt.Errorf("Record %s not found in %s", id, dataset)
-//}
-
 //Get hydrates a model with specified id into result Model
 func (g *gitdb) Get(id string, result Model) error {
+	return g.GetContext(context.Background(), id, result)
+}
+
+//GetContext is Get that gives up once ctx is done
+func (g *gitdb) GetContext(ctx context.Context, id string, result Model) error {
+	unlock, err := g.rlockConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	record, err := g.doGet(id)
 	if err != nil {
 		return err
 	}
 
-	g.events <- newReadEvent("...", id)
+	g.notify(ctx, newReadEvent("...", id))
 
 	return record.Hydrate(result)
 }
 
 func (g *gitdb) Exists(id string) error {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
+
 	_, err := g.doGet(id)
 	if err == nil {
 		g.events <- newReadEvent("...", id)
@@ -101,26 +93,43 @@ func (g *gitdb) Exists(id string) error {
 }
 
 func (g *gitdb) Fetch(dataset string, blocks ...string) ([]*db.Record, error) {
+	return g.FetchContext(context.Background(), dataset, blocks...)
+}
+
+//FetchContext is Fetch that gives up once ctx is done
+func (g *gitdb) FetchContext(ctx context.Context, dataset string, blocks ...string) ([]*db.Record, error) {
+	unlock, err := g.rlockConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	if !g.isRegistered(dataset) {
 		return nil, ErrInvalidDataset
 	}
 
-	dataBlock := db.NewEmptyBlock(g.config.EncryptionKey)
+	dataBlock := db.NewEmptyBlock(g.keysFor(dataset))
 
 	if len(blocks) > 0 {
 		fullPath := filepath.Join(g.dbDir(), dataset)
 		for _, block := range blocks {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
 			blockFile := filepath.Join(fullPath, block+".json")
 			log.Test("Fetching BLOCK records from - " + blockFile)
-			if err := dataBlock.Hydrate(blockFile); err != nil {
+			cached, err := g.readBlock(blockFile)
+			if err != nil {
 				return nil, err
 			}
+			dataBlock.AddFrom(cached)
 		}
 
 		return dataBlock.Records(), nil
 	}
 
-	if err := g.doFetch(dataset, dataBlock); err != nil {
+	if err := g.doFetch(ctx, dataset, dataBlock); err != nil {
 		return nil, err
 	}
 
@@ -128,7 +137,88 @@ func (g *gitdb) Fetch(dataset string, blocks ...string) ([]*db.Record, error) {
 	return dataBlock.Records(), nil
 }
 
-func (g *gitdb) doFetch(dataset string, dataBlock *db.EmptyBlock) error {
+//Iterate calls fn with every record of dataset in order of block name and record id.
+//Only one block is held in memory at a time and blocks are not added to the
+//block cache so iterating does not evict blocks in use. Iteration stops at the first error returned
+//by fn which Iterate returns unless it is ErrStopIteration.
+//The connection is not locked while fn runs so fn may write to it
+func (g *gitdb) Iterate(dataset string, fn func(*db.Record) error) error {
+	blockFiles, err := g.iterateBlocks(dataset)
+	if err != nil {
+		return err
+	}
+
+	for _, blockFile := range blockFiles {
+		records, err := g.iterateBlock(blockFile)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			if err := fn(record); err != nil {
+				if errors.Is(err, ErrStopIteration) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//iterateBlocks returns the block files of dataset in order of block name
+func (g *gitdb) iterateBlocks(dataset string) ([]string, error) {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
+
+	if !g.isRegistered(dataset) {
+		return nil, ErrInvalidDataset
+	}
+
+	if err := g.waitForIndex(context.Background(), dataset); err != nil {
+		return nil, err
+	}
+
+	fullPath := filepath.Join(g.dbDir(), dataset)
+	files, err := ioutil.ReadDir(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var blocks []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" {
+			blocks = append(blocks, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	sort.Strings(blocks)
+
+	blockFiles := make([]string, len(blocks))
+	for i, block := range blocks {
+		blockFiles[i] = filepath.Join(fullPath, block+".json")
+	}
+	return blockFiles, nil
+}
+
+//iterateBlock returns the records of blockFile. A block file
+//removed since iteration started has no records
+func (g *gitdb) iterateBlock(blockFile string) ([]*db.Record, error) {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
+
+	dataBlock := db.NewEmptyBlock(g.keysForFile(blockFile))
+	if err := dataBlock.Hydrate(blockFile); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return dataBlock.Records(), nil
+}
+
+func (g *gitdb) doFetch(ctx context.Context, dataset string, dataBlock *db.EmptyBlock) error {
 
 	fullPath := filepath.Join(g.dbDir(), dataset)
 	//events <- newReadEvent("...", fullPath)
@@ -144,11 +234,17 @@ func (g *gitdb) doFetch(dataset string, dataBlock *db.EmptyBlock) error {
 
 	var fileName string
 	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		fileName = filepath.Join(fullPath, file.Name())
 		if filepath.Ext(fileName) == ".json" {
-			if err := dataBlock.Hydrate(fileName); err != nil {
+			cached, err := g.readBlock(fileName)
+			if err != nil {
 				return err
 			}
+			dataBlock.AddFrom(cached)
 		}
 	}
 
@@ -156,67 +252,168 @@ func (g *gitdb) doFetch(dataset string, dataBlock *db.EmptyBlock) error {
 }
 
 func (g *gitdb) Search(dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
-	if !g.isRegistered(dataset) {
-		return nil, ErrInvalidDataset
+	return g.SearchContext(context.Background(), dataset, searchParams, searchMode)
+}
+
+//SearchContext is Search that gives up once ctx is done
+func (g *gitdb) SearchContext(ctx context.Context, dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	return g.FindContext(ctx, dataset, searchQuery(searchParams, searchMode))
+}
+
+//Find returns all records in dataset matched by query
+func (g *gitdb) Find(dataset string, query *Query) ([]*db.Record, error) {
+	return g.FindContext(context.Background(), dataset, query)
+}
+
+//FindContext is Find that gives up once ctx is done
+func (g *gitdb) FindContext(ctx context.Context, dataset string, query *Query) ([]*db.Record, error) {
+	unlock, err := g.rlockConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	page, err := g.find(ctx, dataset, query)
+	if err != nil {
+		return nil, err
+	}
+	return page.Records, nil
+}
+
+//FindPage returns the page of records in dataset matched by query and the
+//cursor to the next page. Pass Page.Next to Query.After to read the next page
+func (g *gitdb) FindPage(dataset string, query *Query) (*Page, error) {
+	return g.FindPageContext(context.Background(), dataset, query)
+}
+
+//FindPageContext is FindPage that gives up once ctx is done
+func (g *gitdb) FindPageContext(ctx context.Context, dataset string, query *Query) (*Page, error) {
+	unlock, err := g.rlockConn(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
 
-	//searchBlocks return the position of the record in the block
-	//searchBlocks := map[string][][]int{} //index based
-	searchBlocks := map[string]bool{}
-	matchingRecords := map[string]string{}
+	return g.find(ctx, dataset, query)
+}
+
+func (g *gitdb) find(ctx context.Context, dataset string, query *Query) (*Page, error) {
+	matchingRecords, index, err := g.match(ctx, dataset, query)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, searchParam := range searchParams {
-		indexFile := filepath.Join(g.indexDir(), dataset, searchParam.Index+".json")
-		if _, ok := g.indexCache[indexFile]; !ok {
-			g.buildIndexTargeted(dataset)
+	if !query.paged() {
+		records, err := g.hydrateRecords(ctx, matchingRecords)
+		if err != nil {
+			return nil, err
 		}
+		return &Page{Records: records}, nil
+	}
 
-		g.events <- newReadEvent("...", indexFile)
-
-		queryValue := strings.ToLower(searchParam.Value)
-		for recordID, iv := range g.indexCache[indexFile] {
-			addResult := false
-			dbValue := strings.ToLower(iv.(string))
-			switch searchMode {
-			case SearchEquals:
-				addResult = dbValue == queryValue
-			case SearchContains:
-				addResult = strings.Contains(dbValue, queryValue)
-			case SearchStartsWith:
-				addResult = strings.HasPrefix(dbValue, queryValue)
-			case SearchEndsWith:
-				addResult = strings.HasSuffix(dbValue, queryValue)
-			}
+	//order and page using the index so that only
+	//blocks of records on the page are hydrated
+	ids, next := query.paginate(matchingRecords, index)
+	pageRecords := make(map[string]string, len(ids))
+	for _, id := range ids {
+		pageRecords[id] = id
+	}
 
-			if addResult {
-				dataset, block, _, err := ParseID(recordID)
-				if err != nil {
-					return nil, err
-				}
+	records, err := g.hydrateRecords(ctx, pageRecords)
+	if err != nil {
+		return nil, err
+	}
 
-				matchingRecords[recordID] = recordID
-				searchBlocks[g.blockFilePath(dataset, block)] = true
-			}
+	return &Page{Records: orderRecords(records, ids), Next: next}, nil
+}
+
+//match returns the ids of all records in dataset matched by query
+//and the index lookup used to evaluate query
+func (g *gitdb) match(ctx context.Context, dataset string, query *Query) (map[string]string, func(string) gdbSimpleIndex, error) {
+	if !g.isRegistered(dataset) {
+		return nil, nil, ErrInvalidDataset
+	}
+
+	if err := query.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := g.waitForIndex(ctx, dataset); err != nil {
+		return nil, nil, err
+	}
+
+	for _, index := range query.indexes() {
+		g.notify(ctx, newReadEvent("...", g.indexFilePath(dataset, index)))
+	}
+
+	index := func(index string) gdbSimpleIndex {
+		return g.loadIndex(dataset, index)
+	}
+
+	return query.eval(index), index, nil
+}
+
+//hydrateRecords reads the blocks of the given records and returns the records
+func (g *gitdb) hydrateRecords(ctx context.Context, matchingRecords map[string]string) ([]*db.Record, error) {
+	//searchBlocks holds the records to read from each block
+	searchBlocks := map[string][]string{}
+	for recordID := range matchingRecords {
+		dataset, block, _, err := ParseID(recordID)
+		if err != nil {
+			return nil, err
 		}
+		blockFile := g.blockFilePath(dataset, block)
+		searchBlocks[blockFile] = append(searchBlocks[blockFile], recordID)
 	}
 
-	resultBlock := db.NewEmptyBlock(g.config.EncryptionKey)
-	//TODO revisit index based search
-	//for block, pos := range searchBlocks {
-	//	blockFile := filepath.Join(g.dbDir(), dataset, block+".json")
-	//	err := resultBlock.HydrateByPositions(blockFile, pos...)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//}
+	//records are decrypted with the keys of their dataset
+	resultBlocks := map[string]*db.EmptyBlock{}
+	for block, recordIDs := range searchBlocks {
+		dataset := filepath.Base(filepath.Dir(block))
+		resultBlock, ok := resultBlocks[dataset]
+		if !ok {
+			resultBlock = db.NewEmptyBlock(g.keysFor(dataset))
+			resultBlocks[dataset] = resultBlock
+		}
 
-	for block := range searchBlocks {
-		if err := resultBlock.Hydrate(block); err != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(block)
+		if err != nil {
 			log.Error(err.Error())
 			continue
 		}
+
+		if cached := g.blockCache.get(block, info); cached != nil {
+			resultBlock.AddFrom(cached, recordIDs...)
+			continue
+		}
+
+		if g.hydrateByPositions(resultBlock, block, recordIDs) {
+			continue
+		}
+
+		cached, err := g.fillBlock(block, info)
+		if err != nil {
+			log.Error(err.Error())
+			continue
+		}
+		g.updatePositions(block)
+		resultBlock.AddFrom(cached, recordIDs...)
+	}
+
+	datasets := make([]string, 0, len(resultBlocks))
+	for dataset := range resultBlocks {
+		datasets = append(datasets, dataset)
 	}
+	sort.Strings(datasets)
 
-	resultBlock.Filter(matchingRecords)
-	return resultBlock.Records(), nil
+	var records []*db.Record
+	for _, dataset := range datasets {
+		resultBlocks[dataset].Filter(matchingRecords)
+		records = append(records, resultBlocks[dataset].Records()...)
+	}
+	return records, nil
 }
@@ -1,7 +1,12 @@
 package gitdb_test
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/bouggo/log"
 	"github.com/gogitdb/gitdb/v2"
@@ -91,6 +96,64 @@ func TestFetchBlock(t *testing.T) {
 	}
 }
 
+func TestIterate(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("LogEntry", &LogEntry{})
+
+	for i := 1; i <= 5; i++ {
+		if err := testDb.Insert(&LogEntry{EntryId: i, Text: fmt.Sprintf("entry %d", i)}); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	var got []string
+	err := testDb.Iterate("LogEntry", func(record *gitdb.Record) error {
+		entry := &LogEntry{}
+		if err := record.Hydrate(entry); err != nil {
+			return err
+		}
+		got = append(got, entry.Text)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("testDb.Iterate failed: %s", err)
+	}
+
+	want := []string{"entry 1", "entry 2", "entry 3", "entry 4", "entry 5"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("testDb.Iterate want: %v, got: %v", want, got)
+	}
+
+	//iteration stops early without an error
+	count := 0
+	err = testDb.Iterate("LogEntry", func(record *gitdb.Record) error {
+		count++
+		if count == 3 {
+			return gitdb.ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil || count != 3 {
+		t.Errorf("testDb.Iterate stop want: %d records, got: %d (%v)", 3, count, err)
+	}
+
+	//other errors stop iteration and are returned
+	errBad := errors.New("bad record")
+	count = 0
+	err = testDb.Iterate("LogEntry", func(record *gitdb.Record) error {
+		count++
+		return errBad
+	})
+	if err != errBad || count != 1 {
+		t.Errorf("testDb.Iterate error want: %v after 1 record, got: %v after %d", errBad, err, count)
+	}
+
+	if err := testDb.Iterate("Unknown", func(*gitdb.Record) error { return nil }); err != gitdb.ErrInvalidDataset {
+		t.Errorf("testDb.Iterate unregistered dataset want: %v, got: %v", gitdb.ErrInvalidDataset, err)
+	}
+}
+
 //TODO test correctness of search results
 func TestSearch(t *testing.T) {
 	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
@@ -113,6 +176,292 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchModes(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	cases := []struct {
+		name  string
+		param *gitdb.SearchParam
+		mode  gitdb.SearchMode
+		want  int
+	}{
+		{"equals number", &gitdb.SearchParam{Index: "MessageId", Value: "3"}, gitdb.SearchEquals, 1},
+		{"not equals number", &gitdb.SearchParam{Index: "MessageId", Value: "3"}, gitdb.SearchNotEquals, 9},
+		{"greater than number", &gitdb.SearchParam{Index: "MessageId", Value: "4"}, gitdb.SearchGreaterThan, 5},
+		{"less than number", &gitdb.SearchParam{Index: "MessageId", Value: "10"}, gitdb.SearchLessThan, 10},
+		{"between number", &gitdb.SearchParam{Index: "MessageId", Value: "2", To: "4"}, gitdb.SearchBetween, 3},
+		{"greater than invalid number", &gitdb.SearchParam{Index: "MessageId", Value: "abc"}, gitdb.SearchGreaterThan, 0},
+		{"between time", &gitdb.SearchParam{Index: "CreatedAt", Value: "2020-03-29T19:02:36.579284+01:00", To: "2020-03-29T19:02:36.580396+01:00"}, gitdb.SearchBetween, 3},
+		{"greater than date", &gitdb.SearchParam{Index: "CreatedAt", Value: "2020-03-28"}, gitdb.SearchGreaterThan, 10},
+		{"less than string", &gitdb.SearchParam{Index: "From", Value: "bob@example.com"}, gitdb.SearchLessThan, 10},
+		{"contains number", &gitdb.SearchParam{Index: "MessageId", Value: "7"}, gitdb.SearchContains, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := testDb.Search("Message", []*gitdb.SearchParam{tc.param}, tc.mode)
+			if err != nil {
+				t.Errorf("search failed with error - %s", err)
+				return
+			}
+
+			if len(results) != tc.want {
+				t.Errorf("search result count wrong. want: %d, got: %d", tc.want, len(results))
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	cases := []struct {
+		name  string
+		query *gitdb.Query
+		want  int
+	}{
+		{"and", gitdb.And(gitdb.Where("From", gitdb.SearchEquals, "alice@example.com"), gitdb.Where("MessageId", gitdb.SearchGreaterThan, "4")), 5},
+		{"or", gitdb.Or(gitdb.Where("MessageId", gitdb.SearchEquals, "1"), gitdb.Where("MessageId", gitdb.SearchEquals, "2")), 2},
+		{"not", gitdb.Not(gitdb.Where("MessageId", gitdb.SearchLessThan, "3")), 7},
+		{"all", gitdb.And(), 10},
+		{"chained", gitdb.Where("MessageId", gitdb.SearchGreaterThan, "2").And(gitdb.Not(gitdb.Between("MessageId", "5", "6"))), 5},
+		{"no match", gitdb.Where("From", gitdb.SearchEquals, "bob@example.com").Or(gitdb.Where("MessageId", gitdb.SearchEquals, "11")), 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := testDb.Find("Message", tc.query)
+			if err != nil {
+				t.Errorf("testDb.Find failed with error - %s", err)
+				return
+			}
+
+			if len(results) != tc.want {
+				t.Errorf("testDb.Find result count wrong. want: %d, got: %d", tc.want, len(results))
+			}
+		})
+	}
+
+	if _, err := testDb.Find("Message", gitdb.Where("", gitdb.SearchEquals, "1")); err == nil {
+		t.Error("testDb.Find should fail if query index is not set")
+	}
+
+	if _, err := testDb.Find("Message", nil); err == nil {
+		t.Error("testDb.Find should fail if query is nil")
+	}
+}
+
+func TestFindPaging(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	cases := []struct {
+		name  string
+		query *gitdb.Query
+		want  []int
+	}{
+		{"order desc", gitdb.All().OrderBy("MessageId", gitdb.SortDesc).Limit(3), []int{9, 8, 7}},
+		{"offset", gitdb.All().OrderBy("MessageId", gitdb.SortDesc).Offset(3).Limit(3), []int{6, 5, 4}},
+		{"after", gitdb.All().OrderBy("MessageId", gitdb.SortDesc).After("Message/b0/7").Limit(2), []int{6, 5}},
+		{"order by time", gitdb.All().OrderBy("CreatedAt", gitdb.SortAsc).Limit(2), []int{0, 1}},
+		{"filtered", gitdb.Where("MessageId", gitdb.SearchGreaterThan, "6").OrderBy("MessageId", gitdb.SortAsc), []int{7, 8, 9}},
+		{"offset past end", gitdb.All().Offset(20), []int{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			records, err := testDb.Find("Message", tc.query)
+			if err != nil {
+				t.Errorf("testDb.Find failed with error - %s", err)
+				return
+			}
+
+			var got []int
+			for _, record := range records {
+				m := &Message{}
+				if err := record.Hydrate(m); err != nil {
+					t.Errorf("record.Hydrate failed: %s", err)
+					return
+				}
+				got = append(got, m.MessageId)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("want: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := testDb.Find("Message", gitdb.All().Limit(-1)); err == nil {
+		t.Error("testDb.Find should fail if limit is negative")
+	}
+}
+
+func TestFindPageCursor(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 6; i++ {
+		m := getTestMessageWithId(i)
+		m.From = fmt.Sprintf("user%d@example.com", i)
+		if err := testDb.Insert(m); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	messageIds := func(page *gitdb.Page) []int {
+		var got []int
+		for _, record := range page.Records {
+			m := &Message{}
+			if err := record.Hydrate(m); err != nil {
+				t.Fatalf("record.Hydrate failed: %s", err)
+			}
+			got = append(got, m.MessageId)
+		}
+		return got
+	}
+
+	findPage := func(after string) *gitdb.Page {
+		page, err := testDb.FindPage("Message", gitdb.All().OrderBy("From", gitdb.SortAsc).After(after).Limit(2))
+		if err != nil {
+			t.Fatalf("testDb.FindPage failed: %s", err)
+		}
+		return page
+	}
+
+	first := findPage("")
+	if fmt.Sprint(messageIds(first)) != "[0 1]" || len(first.Next) == 0 {
+		t.Fatalf("want first page [0 1] with a cursor, got: %v %q", messageIds(first), first.Next)
+	}
+
+	//the last record of the page changes its order value
+	m := getTestMessageWithId(1)
+	m.From = "zed@example.com"
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	if got := messageIds(findPage(first.Next)); fmt.Sprint(got) != "[2 3]" {
+		t.Errorf("after update want: [2 3], got: %v", got)
+	}
+
+	//the last record of the page is deleted
+	if err := testDb.Delete(gitdb.ID(m)); err != nil {
+		t.Fatalf("testDb.Delete failed: %s", err)
+	}
+
+	second := findPage(first.Next)
+	if got := messageIds(second); fmt.Sprint(got) != "[2 3]" {
+		t.Errorf("after delete want: [2 3], got: %v", got)
+	}
+
+	last := findPage(second.Next)
+	if got := messageIds(last); fmt.Sprint(got) != "[4 5]" || len(last.Next) > 0 {
+		t.Errorf("want last page [4 5] without a cursor, got: %v %q", got, last.Next)
+	}
+
+	if _, err := testDb.FindPage("Message", gitdb.All().After("cursor:???")); err == nil {
+		t.Error("testDb.FindPage should fail if the cursor is invalid")
+	}
+}
+
+func TestCount(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	got, err := testDb.Count("Message", gitdb.Where("MessageId", gitdb.SearchGreaterThan, "4"))
+	if err != nil {
+		t.Errorf("testDb.Count failed with error - %s", err)
+	}
+
+	if want := 5; got != want {
+		t.Errorf("testDb.Count want: %d, got: %d", want, got)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	cases := []struct {
+		name        string
+		aggregation *gitdb.Aggregation
+		group       interface{}
+		want        float64
+	}{
+		{"count", &gitdb.Aggregation{Func: gitdb.AggregateCount}, nil, 10},
+		{"sum", &gitdb.Aggregation{Func: gitdb.AggregateSum, Field: "MessageId"}, nil, 45},
+		{"avg", &gitdb.Aggregation{Func: gitdb.AggregateAvg, Field: "MessageId"}, nil, 4.5},
+		{"min", &gitdb.Aggregation{Func: gitdb.AggregateMin, Field: "MessageId"}, nil, 0},
+		{"max", &gitdb.Aggregation{Func: gitdb.AggregateMax, Field: "MessageId"}, nil, 9},
+		{"group by index", &gitdb.Aggregation{Func: gitdb.AggregateSum, Field: "MessageId", GroupBy: "From"}, "alice@example.com", 45},
+		{"group by field", &gitdb.Aggregation{Func: gitdb.AggregateCount, GroupBy: "To"}, "bob@example.com", 10},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := testDb.Aggregate("Message", gitdb.All(), tc.aggregation)
+			if err != nil {
+				t.Errorf("testDb.Aggregate failed with error - %s", err)
+				return
+			}
+
+			if len(results) != 1 {
+				t.Errorf("testDb.Aggregate want 1 group, got: %d", len(results))
+				return
+			}
+
+			if results[0].Group != tc.group || results[0].Value != tc.want {
+				t.Errorf("want: %v=%v, got: %v=%v", tc.group, tc.want, results[0].Group, results[0].Value)
+			}
+		})
+	}
+
+	if _, err := testDb.Aggregate("Message", gitdb.All(), &gitdb.Aggregation{Func: gitdb.AggregateSum}); err == nil {
+		t.Error("testDb.Aggregate should fail if field is not set")
+	}
+}
+
+func TestAggregateUnindexedField(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	indexedAt := func() time.Time {
+		statuses, err := testDb.IndexStatus()
+		if err != nil {
+			t.Fatalf("testDb.IndexStatus failed with error - %s", err)
+		}
+		for _, status := range statuses {
+			if status.Dataset == "Message" {
+				return status.UpdatedAt
+			}
+		}
+		return time.Time{}
+	}
+
+	//build the index of Message before it is compared
+	if _, err := testDb.Count("Message", gitdb.All()); err != nil {
+		t.Fatalf("testDb.Count failed with error - %s", err)
+	}
+
+	before := indexedAt()
+	results, err := testDb.Aggregate("Message", gitdb.All(), &gitdb.Aggregation{Func: gitdb.AggregateCount, GroupBy: "To"})
+	if err != nil {
+		t.Fatalf("testDb.Aggregate failed with error - %s", err)
+	}
+
+	if len(results) != 1 || results[0].Count != 10 {
+		t.Errorf("testDb.Aggregate want 1 group of 10 records, got: %v", results)
+	}
+
+	//To is not an index of Message so it is read from records without rebuilding the index
+	if after := indexedAt(); !after.Equal(before) {
+		t.Errorf("testDb.Aggregate rebuilt the index of Message at %s", after)
+	}
+}
+
 func BenchmarkFetch(b *testing.B) {
 	teardown := setup(b, getReadTestConfig(gitdb.RecVersion))
 	defer teardown(b)
@@ -134,3 +483,124 @@ func BenchmarkGet(b *testing.B) {
 		log.Test(m.Body)
 	}
 }
+
+func TestSearchText(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Note", &Note{})
+
+	if err := testDb.InsertMany(getTestNotes()); err != nil {
+		t.Fatalf("testDb.InsertMany failed: %s", err)
+	}
+
+	results, err := testDb.SearchText("Note", "refund POLICY")
+	if err != nil {
+		t.Fatalf("testDb.SearchText failed: %s", err)
+	}
+
+	want := []string{"Note/b0/4", "Note/b0/2"}
+	if len(results) != len(want) {
+		t.Fatalf("testDb.SearchText result count wrong. want: %d, got: %d", len(want), len(results))
+	}
+
+	for i, record := range results {
+		if record.ID() != want[i] {
+			t.Errorf("testDb.SearchText result %d want: %s, got: %s", i, want[i], record.ID())
+		}
+	}
+
+	if err := testDb.Insert(&Note{NoteId: 1, Title: "Late checkout", Text: "Guest will leave at noon"}); err != nil {
+		t.Errorf("testDb.Insert failed: %s", err)
+	}
+
+	if err := testDb.Delete("Note/b0/2"); err != nil {
+		t.Errorf("testDb.Delete failed: %s", err)
+	}
+
+	results, err = testDb.SearchText("Note", "refund guest")
+	if err != nil {
+		t.Errorf("testDb.SearchText failed: %s", err)
+	}
+
+	want = []string{"Note/b0/1", "Note/b0/3", "Note/b0/4"}
+	if len(results) != len(want) {
+		t.Fatalf("testDb.SearchText after update want: %d records, got: %d", len(want), len(results))
+	}
+
+	if _, err := testDb.SearchText("Message", "hello"); err == nil {
+		t.Error("testDb.SearchText should fail for datasets without full-text fields")
+	}
+}
+
+func TestSearchTextUpdatesChangedRecords(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Note", &Note{})
+
+	if err := testDb.InsertMany(getTestNotes()); err != nil {
+		t.Fatalf("testDb.InsertMany failed: %s", err)
+	}
+
+	if _, err := testDb.SearchText("Note", "refund"); err != nil {
+		t.Fatalf("testDb.SearchText failed: %s", err)
+	}
+
+	//write the text index and make it look old
+	testDb.Close()
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Note", &Note{})
+
+	textIndex := filepath.Join(dbPath, ".gitdb", "index", "Note", "text.fts")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(textIndex, old, old); err != nil {
+		t.Fatalf("os.Chtimes failed: %s", err)
+	}
+
+	//records whose full-text fields did not change leave the text index as it is
+	if err := testDb.Insert(getTestNotes()[2]); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	testDb.Close()
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Note", &Note{})
+
+	if info, err := os.Stat(textIndex); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("text index should not be written if no full-text field changed (%v)", err)
+	}
+
+	//tokens a record no longer has are removed while tokens other records share are kept
+	if err := testDb.Insert(&Note{NoteId: 3, Title: "Allergies", Text: "Inform the kitchen"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	results, err := testDb.SearchText("Note", "guest")
+	if err != nil || len(results) != 1 || results[0].ID() != "Note/b0/1" {
+		t.Errorf("testDb.SearchText want: Note/b0/1, got: %d records (%v)", len(results), err)
+	}
+}
+
+func TestQueryWaitsForIndexBuild(t *testing.T) {
+	//without an index a full build starts in the background on boot
+	cfg := getReadTestConfig("v1")
+	if err := os.RemoveAll(filepath.Join(cfg.DBPath, ".gitdb", "index")); err != nil {
+		t.Fatalf("os.RemoveAll failed: %s", err)
+	}
+
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	count, err := testDb.Count("Message", gitdb.All())
+	if err != nil {
+		t.Errorf("testDb.Count failed: %s", err)
+	}
+
+	records, err := testDb.Fetch("Message")
+	if err != nil {
+		t.Errorf("testDb.Fetch failed: %s", err)
+	}
+
+	if count == 0 || count != len(records) {
+		t.Errorf("testDb.Count want: %d, got: %d", len(records), count)
+	}
+}
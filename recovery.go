@@ -0,0 +1,124 @@
+package gitdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//recoverFiles repairs files left partially written by a crash. Leftover temp files
+//are removed and blocks that cannot be read are restored from git HEAD. Only files
+//git reports as changed are checked so that opening a database does not read every
+//block. Index files that cannot be read are rebuilt when they are loaded. The files
+//recovered are reported by the next Repair
+func (g *gitdb) recoverFiles() error {
+	files, err := g.driver.dirtyFiles()
+	if err != nil {
+		//without git only temp files can be recovered
+		log.Info("checking for partially written files: " + err.Error())
+		return g.removeTempFiles(g.dbDir(), g.indexDir(), g.positionsDir())
+	}
+
+	restored := map[string]bool{}
+	for _, file := range files {
+		path := filepath.Join(g.dbDir(), file)
+		if isTempFile(path) {
+			log.Info("removing partially written file: " + path)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			g.recovered = append(g.recovered, &RepairChange{Path: path, Message: "removed partially written file"})
+			continue
+		}
+
+		if filepath.Ext(path) != ".json" {
+			continue
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		if err := db.CheckBlock(path); err != nil {
+			log.Error(fmt.Sprintf("partially written block %s: %s", path, err))
+			if err := g.driver.restore(file); err != nil {
+				log.Error("could not restore " + path + " from HEAD: " + err.Error())
+				continue
+			}
+			g.refreshChecksums(path)
+			g.recovered = append(g.recovered, &RepairChange{Path: path, Message: "restored partially written block from HEAD"})
+			restored[filepath.Base(filepath.Dir(path))] = true
+		}
+	}
+
+	//indexes of restored blocks no longer match them
+	for dataset := range restored {
+		if err := os.RemoveAll(g.indexPath(dataset)); err != nil {
+			return err
+		}
+		g.recovered = append(g.recovered, &RepairChange{Path: g.indexPath(dataset), Message: "removed indexes of restored blocks"})
+	}
+
+	return g.removeTempFiles(g.indexDir(), g.positionsDir())
+}
+
+//removeTempFiles removes leftover temp files in dirs
+func (g *gitdb) removeTempFiles(dirs ...string) error {
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() && info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+
+			if !info.IsDir() && isTempFile(path) {
+				log.Info("removing partially written file: " + path)
+				if err := os.Remove(path); err != nil {
+					return err
+				}
+				g.recovered = append(g.recovered, &RepairChange{Path: path, Message: "removed partially written file"})
+			}
+
+			return nil
+		})
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//checkIndexFile returns an error if the index file at path cannot be read
+func checkIndexFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	//sealed index files are not decrypted as the key may be wrong
+	if isSealedIndex(data) {
+		_, err = newIndexReader(data, sealedIndexFileMagic)
+		return err
+	}
+
+	switch filepath.Ext(path) {
+	case ".idx":
+		_, err = decodeIndex(data)
+	case ".fts":
+		_, err = decodeTextIndex(data)
+	case ".json":
+		err = json.Unmarshal(data, &gdbSimpleIndex{})
+	}
+
+	return err
+}
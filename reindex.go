@@ -0,0 +1,287 @@
+package gitdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//IndexStatus reports the state of the index of a dataset
+type IndexStatus struct {
+	Dataset string
+	//Records is the number of records in the index
+	Records int
+	//Fresh is true if no block of the dataset has changed since the index was last updated
+	Fresh bool
+	//UpdatedAt is the last time the index was updated
+	UpdatedAt time.Time
+	//Building is true while the index is being built.
+	//BlocksIndexed of Blocks report the progress of the build
+	Building      bool
+	Blocks        int
+	BlocksIndexed int
+}
+
+//indexBuild tracks the progress of an index build
+type indexBuild struct {
+	blocks  int
+	indexed int
+	done    chan struct{}
+}
+
+//startBuild registers a build of the index of dataset.
+//ok is false if the index of dataset is already being built
+func (g *gitdb) startBuild(dataset string) (build *indexBuild, ok bool) {
+	g.buildMu.Lock()
+	defer g.buildMu.Unlock()
+
+	if build, ok := g.builds[dataset]; ok {
+		return build, false
+	}
+
+	if g.builds == nil {
+		g.builds = make(map[string]*indexBuild)
+	}
+
+	build = &indexBuild{done: make(chan struct{})}
+	g.builds[dataset] = build
+	return build, true
+}
+
+func (g *gitdb) finishBuild(dataset string, build *indexBuild) {
+	g.buildMu.Lock()
+	delete(g.builds, dataset)
+	g.buildMu.Unlock()
+	close(build.done)
+}
+
+func (g *gitdb) currentBuild(dataset string) *indexBuild {
+	g.buildMu.Lock()
+	defer g.buildMu.Unlock()
+	return g.builds[dataset]
+}
+
+//waitForIndex blocks until an in-progress build of the index of dataset is done or ctx is done.
+//If Config.FailOnIndexBuild is set it returns ErrIndexBuilding instead of waiting
+func (g *gitdb) waitForIndex(ctx context.Context, dataset string) error {
+	build := g.currentBuild(dataset)
+	if build == nil {
+		return nil
+	}
+
+	if g.config.FailOnIndexBuild {
+		return ErrIndexBuilding
+	}
+
+	log.Info("waiting for index build: " + dataset)
+	select {
+	case <-build.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//waitForBuilds blocks until all in-progress index builds are done
+func (g *gitdb) waitForBuilds() {
+	g.buildMu.Lock()
+	var builds []*indexBuild
+	for _, build := range g.builds {
+		builds = append(builds, build)
+	}
+	g.buildMu.Unlock()
+
+	for _, build := range builds {
+		<-build.done
+	}
+}
+
+//buildIndex rebuilds the index of dataset from its blocks. The new index replaces
+//the cached index of dataset once all blocks have been indexed
+func (g *gitdb) buildIndex(dataset string, build *indexBuild) {
+	defer g.finishBuild(dataset, build)
+
+	model := g.modelFor(dataset)
+	if model == nil {
+		log.Error("model not found in registry or factory: " + dataset)
+		return
+	}
+
+	log.Info("Building index for dataset: " + dataset)
+	ds := db.LoadDataset(filepath.Join(g.dbDir(), dataset), g.keysFor(dataset))
+	blocks := ds.Blocks()
+
+	g.buildMu.Lock()
+	build.blocks = len(blocks)
+	g.buildMu.Unlock()
+
+	fresh := make(gdbSimpleIndexCache)
+	for _, block := range blocks {
+		g.indexBlock(fresh, newIndex, dataset, model, block)
+
+		g.buildMu.Lock()
+		build.indexed++
+		g.buildMu.Unlock()
+	}
+
+	g.indexMu.Lock()
+	g.replaceDatasetIndexes(dataset, model, fresh)
+	g.indexMu.Unlock()
+}
+
+//replaceDatasetIndexes replaces the cached indexes of dataset with fresh.
+//Caller must hold indexMu
+func (g *gitdb) replaceDatasetIndexes(dataset string, model Model, fresh gdbSimpleIndexCache) {
+	for indexFile := range g.indexCache {
+		if g.isDatasetIndex(indexFile, dataset) {
+			g.indexCache[indexFile] = make(gdbSimpleIndex)
+			g.markDirty(indexFile)
+		}
+	}
+
+	for indexFile, index := range fresh {
+		g.indexCache[indexFile] = index
+		g.markDirty(indexFile)
+	}
+
+	//an empty dataset still has an id index
+	if _, ok := g.indexCache[g.indexFilePath(dataset, "id")]; !ok {
+		g.indexCache[g.indexFilePath(dataset, "id")] = make(gdbSimpleIndex)
+		g.markDirty(g.indexFilePath(dataset, "id"))
+	}
+
+	if fields := model.GetSchema().fullTextFields(); len(fields) > 0 {
+		g.buildTextIndex(dataset, fields)
+	}
+
+	g.touchIndex(dataset)
+}
+
+//touchIndex records that the index of dataset is up to date. Caller must hold indexMu
+func (g *gitdb) touchIndex(dataset string) {
+	if g.indexedAt == nil {
+		g.indexedAt = make(map[string]time.Time)
+	}
+	g.indexedAt[dataset] = time.Now()
+}
+
+//datasetNames returns the names of all datasets on disk and in the registry
+func (g *gitdb) datasetNames() []string {
+	names := map[string]bool{}
+	for _, ds := range db.LoadDatasets(g.dbDir(), g.keysFor) {
+		names[ds.Name()] = true
+	}
+
+	g.regMu.RLock()
+	for dataset := range g.registry {
+		names[dataset] = true
+	}
+	g.regMu.RUnlock()
+
+	var datasets []string
+	for name := range names {
+		datasets = append(datasets, name)
+	}
+	sort.Strings(datasets)
+	return datasets
+}
+
+//Reindex rebuilds the indexes of datasets from their blocks.
+//All registered datasets are reindexed if none are given
+func (g *gitdb) Reindex(datasets ...string) error {
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
+
+	return g.reindex(datasets...)
+}
+
+func (g *gitdb) reindex(datasets ...string) error {
+	if len(datasets) == 0 {
+		for _, dataset := range g.datasetNames() {
+			if g.isRegistered(dataset) {
+				datasets = append(datasets, dataset)
+			}
+		}
+	}
+
+	for _, dataset := range datasets {
+		if !g.isRegistered(dataset) {
+			return ErrInvalidDataset
+		}
+	}
+
+	for _, dataset := range datasets {
+		build, ok := g.startBuild(dataset)
+		for !ok {
+			<-build.done
+			build, ok = g.startBuild(dataset)
+		}
+		g.buildIndex(dataset, build)
+	}
+
+	return g.flushIndex()
+}
+
+//IndexStatus reports the state of the index of every dataset
+func (g *gitdb) IndexStatus() ([]*IndexStatus, error) {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
+
+	var statuses []*IndexStatus
+	for _, dataset := range g.datasetNames() {
+		status := &IndexStatus{Dataset: dataset}
+
+		g.buildMu.Lock()
+		if build, ok := g.builds[dataset]; ok {
+			status.Building = true
+			status.Blocks = build.blocks
+			status.BlocksIndexed = build.indexed
+		}
+		g.buildMu.Unlock()
+
+		idIndex := g.indexFilePath(dataset, "id")
+		g.indexMu.Lock()
+		index, _ := g.cacheIndex(idIndex)
+		status.Records = len(index)
+		status.UpdatedAt = g.indexedAt[dataset]
+		g.indexMu.Unlock()
+
+		if status.UpdatedAt.IsZero() {
+			if info, err := os.Stat(idIndex); err == nil {
+				status.UpdatedAt = info.ModTime()
+			} else if info, err := os.Stat(legacyIndexFilePath(idIndex)); err == nil {
+				status.UpdatedAt = info.ModTime()
+			}
+		}
+
+		status.Fresh = !status.Building && !status.UpdatedAt.IsZero() &&
+			!g.lastBlockChange(dataset).After(status.UpdatedAt)
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+//lastBlockChange returns the last time a block of dataset was modified
+func (g *gitdb) lastBlockChange(dataset string) time.Time {
+	var last time.Time
+	files, err := ioutil.ReadDir(filepath.Join(g.dbDir(), dataset))
+	if err != nil {
+		return last
+	}
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".json" && file.ModTime().After(last) {
+			last = file.ModTime()
+		}
+	}
+
+	return last
+}
@@ -0,0 +1,368 @@
+package gitdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//RepairChange describes a change made by Repair or a problem it could not fix
+type RepairChange struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (c *RepairChange) String() string {
+	return c.Path + ": " + c.Message
+}
+
+//RepairReport is the result of Repair
+type RepairReport struct {
+	//Changes holds the changes made to the database
+	Changes []*RepairChange `json:"changes"`
+	//Unresolved holds the problems Repair could not fix
+	Unresolved []*RepairChange `json:"unresolved"`
+}
+
+func (r *RepairReport) changed(path, format string, args ...interface{}) {
+	r.Changes = append(r.Changes, &RepairChange{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *RepairReport) unresolved(path, format string, args ...interface{}) {
+	r.Unresolved = append(r.Unresolved, &RepairChange{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+//Repair fixes the problems Verify reports as errors and problems of the git repository.
+//A corrupt HEAD is reset to its last valid commit, a corrupt git index is rebuilt, missing
+//objects are fetched again from the online remote, unreadable blocks and bad records are
+//moved to .gitdb/quarantine and indexes that are unreadable or out of sync are rebuilt.
+//The changes reported include the files recovered when the connection was opened
+func (g *gitdb) Repair() (*RepairReport, error) {
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
+
+	report := &RepairReport{Changes: append([]*RepairChange{}, g.recovered...), Unresolved: []*RepairChange{}}
+	g.recovered = nil
+	if err := g.driver.repair(report); err != nil {
+		return report, err
+	}
+
+	quarantine := filepath.Join(g.quarantineDir(), time.Now().Format("20060102150405"))
+	for _, dataset := range g.datasetNames() {
+		if err := g.waitForIndex(context.Background(), dataset); err != nil {
+			return report, err
+		}
+
+		quarantined, err := g.quarantineDataset(report, dataset, quarantine)
+		if err != nil {
+			return report, err
+		}
+
+		if err := g.repairIndex(report, dataset); err != nil {
+			return report, err
+		}
+
+		if quarantined {
+			e := newWriteEvent("Repair: quarantined bad data of "+dataset, filepath.Join(g.dbDir(), dataset), g.autoCommit)
+			if err := g.commitEvent(context.Background(), e); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+//quarantineDataset moves unreadable blocks and bad records of dataset to quarantine
+//and reports whether the dataset was changed
+func (g *gitdb) quarantineDataset(report *RepairReport, dataset, quarantine string) (bool, error) {
+	datasetPath := filepath.Join(g.dbDir(), dataset)
+	files, err := ioutil.ReadDir(datasetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	changed := false
+	for _, file := range files {
+		blockFile := filepath.Join(datasetPath, file.Name())
+		if file.IsDir() || filepath.Ext(blockFile) != ".json" {
+			continue
+		}
+
+		block := strings.TrimSuffix(file.Name(), ".json")
+		dst := filepath.Join(quarantine, dataset, file.Name())
+
+		data, err := ioutil.ReadFile(blockFile)
+		if err != nil {
+			return changed, err
+		}
+
+		dataBlock, err := db.ParseBlock(data)
+		if err != nil {
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return changed, err
+			}
+			if err := os.Rename(blockFile, dst); err != nil {
+				return changed, err
+			}
+
+			g.blockCache.remove(blockFile)
+			g.resetBlockFills(dataset)
+			report.changed(blockFile, "moved unreadable block to %s", dst)
+			changed = true
+			continue
+		}
+
+		bad := map[string]string{}
+		for _, record := range dataBlock.Records() {
+			recordDataset, recordBlock, _, err := ParseID(record.ID())
+			if err != nil || recordDataset != dataset || recordBlock != block {
+				bad[record.ID()] = record.Data()
+				continue
+			}
+
+			//records are not moved because of a wrong or missing key
+			switch err := g.verifyRecord(record.Data(), g.keysFor(dataset)); err {
+			case nil:
+			case errRecordNoKey, errRecordKey:
+				report.unresolved(blockFile, "record %s: %s", record.ID(), err)
+			default:
+				bad[record.ID()] = record.Data()
+			}
+		}
+
+		if len(bad) == 0 {
+			continue
+		}
+
+		if err := g.quarantineRecords(blockFile, dst, bad); err != nil {
+			return changed, err
+		}
+		report.changed(blockFile, "moved %d bad records to %s", len(bad), dst)
+		changed = true
+	}
+
+	return changed, nil
+}
+
+//quarantineRecords writes records to a block file at dst and removes them from blockFile
+func (g *gitdb) quarantineRecords(blockFile, dst string, records map[string]string) error {
+	b, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(dst, b, 0644); err != nil {
+		return err
+	}
+
+	dataBlock := db.LoadBlock(blockFile, g.keysForFile(blockFile))
+	for recordID := range records {
+		dataBlock.Delete(recordID)
+	}
+
+	g.resetBlockFills(filepath.Base(filepath.Dir(blockFile)))
+	return g.writeBlock(blockFile, dataBlock)
+}
+
+//repairIndex rebuilds the indexes of dataset if an index file is unreadable
+//or the indexes do not match the blocks of dataset
+func (g *gitdb) repairIndex(report *RepairReport, dataset string) error {
+	files, _ := ioutil.ReadDir(g.indexPath(dataset))
+	broken := false
+	for _, file := range files {
+		indexFile := filepath.Join(g.indexPath(dataset), file.Name())
+		if err := checkIndexFile(indexFile); err != nil {
+			if err := os.Remove(indexFile); err != nil {
+				return err
+			}
+			report.changed(indexFile, "removed unreadable index file")
+			broken = true
+		}
+	}
+
+	if !g.isRegistered(dataset) {
+		return g.repairIndexFiles(report, dataset)
+	}
+
+	if broken {
+		if err := g.reindex(dataset); err != nil {
+			return err
+		}
+		report.changed(g.indexPath(dataset), "rebuilt indexes")
+		return nil
+	}
+
+	drift, err := g.checkIndex(dataset, true)
+	if err != nil {
+		return err
+	}
+
+	if drift.Repaired {
+		report.changed(g.indexPath(dataset), "rebuilt indexes: %d missing, %d stale and %d changed records",
+			len(drift.Missing), len(drift.Stale), len(drift.Changed))
+	}
+
+	return nil
+}
+
+//repairIndexFiles repairs the index files of a dataset that is not registered from the
+//record ids of its blocks. The id index is rebuilt and records that do not exist are removed
+//from the other indexes. Their values cannot be computed without the model of the dataset so
+//indexes missing records are removed and rebuilt when the dataset is next used with its model
+func (g *gitdb) repairIndexFiles(report *RepairReport, dataset string) error {
+	//index files are sealed if the dataset holds encrypted data
+	keys := g.keysFor(dataset)
+	sealed := false
+	recordIDs := map[string]bool{}
+	for _, block := range db.LoadDataset(filepath.Join(g.dbDir(), dataset), keys).Blocks() {
+		for _, record := range block.Records() {
+			recordIDs[record.ID()] = true
+			sealed = sealed || record.Encrypted()
+		}
+	}
+
+	files, _ := ioutil.ReadDir(g.indexPath(dataset))
+	for _, file := range files {
+		sealed = sealed || isSealedIndexFile(filepath.Join(g.indexPath(dataset), file.Name()))
+	}
+	if sealed && keys.Empty() {
+		report.unresolved(g.indexPath(dataset), "index files are sealed and Config.EncryptionKey is not set")
+		return nil
+	}
+
+	//write seals index files like the index files of the dataset
+	write := func(indexFile string, data []byte) error {
+		if sealed {
+			var err error
+			if data, err = sealIndex(data, keys); err != nil {
+				return err
+			}
+		}
+		return writeFileAtomic(indexFile, data, 0744)
+	}
+
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	idIndex := make(gdbSimpleIndex, len(recordIDs))
+	for recordID := range recordIDs {
+		idIndex[recordID] = recordID
+	}
+
+	idFile := g.indexFilePath(dataset, "id")
+	index, err := g.readIndexFile(idFile)
+	if err != nil && !os.IsNotExist(err) {
+		//unreadable index files were removed so the index file does not open with the key
+		report.unresolved(idFile, "index file cannot be opened with Config.EncryptionKey")
+		return nil
+	}
+
+	if !reflect.DeepEqual(index, idIndex) {
+		if len(recordIDs) > 0 || err == nil {
+			data, err := encodeIndex(idIndex)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(g.indexPath(dataset), 0755); err != nil {
+				return err
+			}
+			if err := write(idFile, data); err != nil {
+				return err
+			}
+			report.changed(idFile, "rebuilt index of %d records", len(recordIDs))
+		}
+	}
+	delete(g.indexCache, idFile)
+
+	for _, file := range files {
+		indexFile := filepath.Join(g.indexPath(dataset), file.Name())
+		if indexFile == idFile || isTempFile(indexFile) {
+			continue
+		}
+
+		switch filepath.Ext(indexFile) {
+		case ".idx":
+			index, err := g.readIndexFile(indexFile)
+			if err != nil {
+				continue
+			}
+			delete(g.indexCache, indexFile)
+
+			missing, stale := 0, 0
+			for recordID := range recordIDs {
+				if _, ok := index[recordID]; !ok {
+					missing++
+				}
+			}
+			for recordID := range index {
+				if !recordIDs[recordID] {
+					delete(index, recordID)
+					stale++
+				}
+			}
+
+			if missing > 0 {
+				if err := os.Remove(indexFile); err != nil {
+					return err
+				}
+				report.changed(indexFile, "removed index missing %d records to be rebuilt when the dataset is next used", missing)
+				continue
+			}
+
+			if stale > 0 {
+				data, err := encodeIndex(index)
+				if err != nil {
+					return err
+				}
+				if err := write(indexFile, data); err != nil {
+					return err
+				}
+				report.changed(indexFile, "removed %d records that do not exist", stale)
+			}
+		case ".fts":
+			data, err := ioutil.ReadFile(indexFile)
+			if err != nil {
+				continue
+			}
+			if data, err = openIndex(data, keys); err != nil {
+				continue
+			}
+			index, err := decodeTextIndex(data)
+			if err != nil {
+				continue
+			}
+			delete(g.textIndexCache, indexFile)
+
+			stale := 0
+			index.remove(func(recordID string) bool {
+				if !recordIDs[recordID] {
+					stale++
+					return true
+				}
+				return false
+			})
+
+			if stale > 0 {
+				if err := write(indexFile, encodeTextIndex(index)); err != nil {
+					return err
+				}
+				report.changed(indexFile, "removed records that do not exist")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package gitdb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//RotateKey re-encrypts every encrypted record and field with newKey block by block and
+//commits all blocks at once. Datasets with a key in Config.DatasetKeys keep their key.
+//Records already encrypted with newKey are re-encrypted too so an interrupted
+//rotation can be run again. If a record cannot be decrypted with oldKey nothing is
+//changed. Once done, sealed index files are sealed again with newKey, newKey is the
+//encryption key of the connection and oldKey is kept in its key ring to read records
+//written by clients still using it
+func (g *gitdb) RotateKey(oldKey, newKey string) error {
+	if err := crypto.ValidKey(oldKey); err != nil {
+		return err
+	}
+	if err := crypto.ValidKey(newKey); err != nil {
+		return err
+	}
+
+	g.txMu.Lock()
+	defer g.txMu.Unlock()
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
+
+	var datasets []string
+	for _, dataset := range g.datasetNames() {
+		//datasets with their own key keep it
+		if _, ok := g.datasetKeys[dataset]; !ok {
+			datasets = append(datasets, dataset)
+		}
+	}
+
+	if err := g.rotateDatasets("RotateKey", datasets, oldKey, newKey); err != nil {
+		return err
+	}
+
+	g.keys.Rotate(newKey, oldKey)
+	//keys of a KeyProvider are kept out of Config
+	if g.config.KeyProvider == nil {
+		g.config.EncryptionKey = newKey
+		g.config.KeyRing = g.keys.Older()
+	}
+
+	return g.resealDatasets(datasets)
+}
+
+//RotateDatasetKey re-encrypts the encrypted records and fields of dataset with newKey
+//like RotateKey. Once done, newKey is the key of dataset in Config.DatasetKeys and oldKey
+//is kept in the key ring of dataset. Other datasets are not changed
+func (g *gitdb) RotateDatasetKey(dataset, oldKey, newKey string) error {
+	if err := crypto.ValidKey(oldKey); err != nil {
+		return err
+	}
+	if err := crypto.ValidKey(newKey); err != nil {
+		return err
+	}
+
+	g.txMu.Lock()
+	defer g.txMu.Unlock()
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
+
+	//datasets are rotated without their model like RotateKey does
+	found := false
+	for _, name := range g.datasetNames() {
+		found = found || name == dataset
+	}
+	if !found {
+		return ErrInvalidDataset
+	}
+
+	if err := g.rotateDatasets("RotateDatasetKey: "+dataset, []string{dataset}, oldKey, newKey); err != nil {
+		return err
+	}
+
+	//a dataset without its own key keeps the connection key to read records written by clients still using it
+	keys, ok := g.datasetKeys[dataset]
+	if !ok {
+		keys = crypto.NewKeyRing(newKey, append([]string{g.keys.Key()}, g.keys.Older()...)...)
+		g.datasetKeys[dataset] = keys
+	}
+	keys.Rotate(newKey, oldKey)
+
+	datasetKeys := map[string]string{dataset: newKey}
+	for name, key := range g.config.DatasetKeys {
+		if name != dataset {
+			datasetKeys[name] = key
+		}
+	}
+	g.config.DatasetKeys = datasetKeys
+
+	return g.resealDatasets([]string{dataset})
+}
+
+//rotateDatasets re-encrypts the records of datasets from oldKey to newKey and commits them at once
+func (g *gitdb) rotateDatasets(name string, datasets []string, oldKey, newKey string) error {
+	from := crypto.NewKeyRing(oldKey, newKey)
+	to := crypto.NewKeyRing(newKey)
+
+	g.begin()
+	records := 0
+	for _, dataset := range datasets {
+		if err := g.waitForIndex(context.Background(), dataset); err != nil {
+			return g.rollback(err)
+		}
+
+		n, err := g.rotateDataset(dataset, from, to)
+		if err != nil {
+			return g.rollback(err)
+		}
+		records += n
+	}
+
+	if err := g.end(context.Background(), name); err != nil {
+		return err
+	}
+
+	g.blockCache.clear()
+	log.Info(fmt.Sprintf("re-encrypted %d records", records))
+	return nil
+}
+
+//resealDatasets seals the index files of datasets again with their current key
+func (g *gitdb) resealDatasets(datasets []string) error {
+	for _, dataset := range datasets {
+		g.resealIndexes(dataset)
+	}
+	return g.flushIndex()
+}
+
+//resealIndexes marks the sealed index files of dataset dirty
+//so that the next flushIndex seals them with the current key
+func (g *gitdb) resealIndexes(dataset string) {
+	if !g.sealsIndexes(dataset) {
+		return
+	}
+
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	g.loadDatasetIndexes(dataset)
+	for indexFile := range g.indexCache {
+		if g.isDatasetIndex(indexFile, dataset) {
+			g.markDirty(indexFile)
+		}
+	}
+
+	textIndexFile := g.textIndexFilePath(dataset)
+	if _, ok := g.textIndexCache[textIndexFile]; !ok {
+		if index := g.readTextIndex(textIndexFile); index != nil {
+			g.textIndexCache[textIndexFile] = index
+		}
+	}
+	if _, ok := g.textIndexCache[textIndexFile]; ok {
+		g.markDirty(textIndexFile)
+	}
+}
+
+//rotateDataset re-encrypts the records of dataset and returns the number of records re-encrypted
+func (g *gitdb) rotateDataset(dataset string, from, to *crypto.KeyRing) (int, error) {
+	fullPath := filepath.Join(g.dbDir(), dataset)
+	files, err := ioutil.ReadDir(fullPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	records := 0
+	for _, file := range files {
+		blockFile := filepath.Join(fullPath, file.Name())
+		if file.IsDir() || filepath.Ext(blockFile) != ".json" {
+			continue
+		}
+
+		dataBlock, err := db.ReadBlock(blockFile, from)
+		if err != nil {
+			return records, err
+		}
+
+		n, err := dataBlock.Reencrypt(from, to)
+		if err != nil {
+			return records, fmt.Errorf("%s: %w", blockFile, err)
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		if err := g.writeBlock(blockFile, dataBlock); err != nil {
+			return records, err
+		}
+		g.touch(dataset)
+		records += n
+	}
+
+	g.resetBlockFills(dataset)
+	return records, nil
+}
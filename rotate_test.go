@@ -0,0 +1,157 @@
+package gitdb_test
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+const newKey = "0123456789abcdef0123456789abcdef"
+
+func TestRotateKey(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Note", &Note{})
+
+	oldKey := getConfig().EncryptionKey
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+	note := &Note{NoteId: 1, Title: "plain", Text: "not encrypted"}
+	if err := testDb.Insert(note); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+	before := readBlockFile(t, blockFile)
+	commits := commitCount(t)
+
+	if err := testDb.RotateKey(oldKey, newKey); err != nil {
+		t.Fatalf("testDb.RotateKey failed: %s", err)
+	}
+
+	//every encrypted record was re-encrypted in a single commit
+	after := readBlockFile(t, blockFile)
+	for id, stored := range after {
+		if stored == before[id] {
+			t.Errorf("record %s was not re-encrypted", id)
+		}
+	}
+
+	if got := commitCount(t); got != commits+1 {
+		t.Errorf("RotateKey want: 1 commit, got: %d", got-commits)
+	}
+
+	if key := testDb.Config().EncryptionKey; key != newKey {
+		t.Errorf("Config.EncryptionKey want: %s, got: %s", newKey, key)
+	}
+
+	if err := testDb.Get(gitdb.ID(getTestMessageWithId(1)), &Message{}); err != nil {
+		t.Errorf("testDb.Get failed: %s", err)
+	}
+
+	//records only decrypt with the new key
+	testDb.Close()
+	cfg := getConfig()
+	cfg.EncryptionKey = newKey
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+	testDb.RegisterModel("Note", &Note{})
+
+	if err := testDb.Get(gitdb.ID(getTestMessageWithId(1)), &Message{}); err != nil {
+		t.Errorf("testDb.Get failed: %s", err)
+	}
+
+	if err := testDb.Get(gitdb.ID(note), &Note{}); err != nil {
+		t.Errorf("testDb.Get failed: %s", err)
+	}
+
+	testDb.Close()
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Get(gitdb.ID(getTestMessageWithId(1)), &Message{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+}
+
+func TestRotateKeyWithWrongKey(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	commits := commitCount(t)
+	if err := testDb.RotateKey("00000000000000000000000000000000", newKey); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.RotateKey want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+
+	if err := testDb.RotateKey(getConfig().EncryptionKey, "short"); !errors.Is(err, gitdb.ErrEncryptionKey) {
+		t.Errorf("testDb.RotateKey want: %s, got: %v", gitdb.ErrEncryptionKey, err)
+	}
+
+	//nothing was changed
+	if got := commitCount(t); got != commits {
+		t.Errorf("RotateKey want: no commit, got: %d", got-commits)
+	}
+
+	if err := testDb.Get(gitdb.ID(getTestMessageWithId(1)), &Message{}); err != nil {
+		t.Errorf("testDb.Get failed: %s", err)
+	}
+}
+
+func TestKeyRing(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//records of the old key are read during a rollout of the new key
+	testDb.Close()
+	cfg := getConfig()
+	cfg.KeyRing = []string{cfg.EncryptionKey}
+	cfg.EncryptionKey = newKey
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Insert(getTestMessageWithId(2)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	for _, id := range []int{1, 2} {
+		if err := testDb.Get(gitdb.ID(getTestMessageWithId(id)), &Message{}); err != nil {
+			t.Errorf("testDb.Get failed: %s", err)
+		}
+	}
+
+	cfg.KeyRing = []string{"short"}
+	if err := cfg.Validate(); !errors.Is(err, gitdb.ErrEncryptionKey) {
+		t.Errorf("cfg.Validate want: %s, got: %v", gitdb.ErrEncryptionKey, err)
+	}
+}
+
+func commitCount(t *testing.T) int {
+	out, err := exec.Command("git", "-C", filepath.Join(dbPath, "data"), "rev-list", "--count", "HEAD").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git rev-list failed: %s", out)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
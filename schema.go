@@ -1,7 +1,6 @@
 package gitdb
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -10,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
 //Schema holds functions for generating a model id
@@ -18,17 +18,112 @@ type Schema struct {
 	block   string
 	record  string
 	indexes map[string]interface{}
+	unique  map[string]bool
+
+	fullText  map[string]bool
+	encrypted map[string]bool
+
+	blockMethod BlockMethod
+	blockLimit  int64
+	format      BlockFormat
+	codec       BlockCodec
 
 	internal bool
 }
 
+//SchemaOption configures optional features of a Schema
+type SchemaOption func(*Schema)
+
+//UniqueIndex marks indexes as unique. Insert fails with ErrUniqueViolation
+//if another record in the dataset already holds the same value
+func UniqueIndex(names ...string) SchemaOption {
+	return func(s *Schema) {
+		if s.unique == nil {
+			s.unique = make(map[string]bool)
+		}
+		for _, name := range names {
+			s.unique[name] = true
+		}
+	}
+}
+
+//FullText marks indexes as full-text. The words of full-text indexes
+//are kept in an inverted index searched by SearchText
+func FullText(names ...string) SchemaOption {
+	return func(s *Schema) {
+		if s.fullText == nil {
+			s.fullText = make(map[string]bool)
+		}
+		for _, name := range names {
+			s.fullText[name] = true
+		}
+	}
+}
+
+//EncryptFields marks fields of a model as encrypted. Each field is encrypted on its own
+//so the other fields of its records stay readable in git. Fields are named as in the JSON
+//of the model and can also be marked with the struct tag `gitdb:"encrypt"`
+func EncryptFields(names ...string) SchemaOption {
+	return func(s *Schema) {
+		if s.encrypted == nil {
+			s.encrypted = make(map[string]bool)
+		}
+		for _, name := range names {
+			s.encrypted[name] = true
+		}
+	}
+}
+
+//BlockFormat is the format of block files
+type BlockFormat string
+
+const (
+	//BlockFormatJSON writes blocks as an indented JSON object of record id => record
+	BlockFormatJSON = BlockFormat(db.FormatJSON)
+	//BlockFormatLines writes blocks with one id<TAB>record line per record sorted by id
+	//so that git diffs and merges work line by line
+	BlockFormatLines = BlockFormat(db.FormatLines)
+)
+
+//StoreAs sets the format blocks of a dataset are written in.
+//Blocks are read in any format so a dataset can be switched at any time
+func StoreAs(format BlockFormat) SchemaOption {
+	return func(s *Schema) {
+		s.format = format
+	}
+}
+
+//BlockCodec is the compression of block files
+type BlockCodec string
+
+const (
+	//BlockCodecNone writes blocks uncompressed
+	BlockCodecNone = BlockCodec(db.CodecNone)
+	//BlockCodecGzip compresses blocks with gzip
+	BlockCodecGzip = BlockCodec(db.CodecGzip)
+)
+
+//CompressWith sets the codec blocks of a dataset are compressed with.
+//Compressed and uncompressed blocks are both read so a dataset can be switched at any time
+func CompressWith(codec BlockCodec) SchemaOption {
+	return func(s *Schema) {
+		s.codec = codec
+	}
+}
+
 //NewSchema constructs a *Schema
-func NewSchema(name, block, record string, indexes map[string]interface{}) *Schema {
-	return &Schema{dataset: name, block: block, record: record, indexes: indexes}
+func NewSchema(name, block, record string, indexes map[string]interface{}, options ...SchemaOption) *Schema {
+	s := &Schema{dataset: name, block: block, record: record, indexes: indexes}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
-func newSchema(name, block, record string, indexes map[string]interface{}) *Schema {
-	return &Schema{dataset: name, block: block, record: record, indexes: indexes, internal: true}
+func newSchema(name, block, record string, indexes map[string]interface{}, options ...SchemaOption) *Schema {
+	s := NewSchema(name, block, record, indexes, options...)
+	s.internal = true
+	return s
 }
 
 //name returns name of schema
@@ -56,7 +151,8 @@ func (a *Schema) Validate() error {
 		return fmt.Errorf("%s is a reserved Schema Name", a.dataset)
 	}
 
-	if !a.validName(a.block) {
+	//names of blocks rolled over by BlockPolicy are reserved
+	if !a.validName(a.block) || strings.Contains(a.block, rolloverSeparator) {
 		return errors.New("Invalid Schema Block ID")
 	}
 
@@ -68,6 +164,30 @@ func (a *Schema) Validate() error {
 		return fmt.Errorf("%s is a reserved index name", "id")
 	}
 
+	for name := range a.unique {
+		if _, ok := a.indexes[name]; !ok {
+			return fmt.Errorf("unique index %s is not an index", name)
+		}
+	}
+
+	if a.blockLimit < 0 || (a.blockLimit > 0 && a.blockMethod != BlockByCount && a.blockMethod != BlockBySize) {
+		return errors.New("Invalid Schema Block Policy")
+	}
+
+	if len(a.format) > 0 && !db.Format(a.format).Valid() {
+		return errors.New("Invalid Schema Block Format")
+	}
+
+	if len(a.codec) > 0 && !db.Codec(a.codec).Valid() {
+		return errors.New("Invalid Schema Block Codec")
+	}
+
+	for name := range a.fullText {
+		if _, ok := a.indexes[name]; !ok {
+			return fmt.Errorf("full-text index %s is not an index", name)
+		}
+	}
+
 	return nil
 }
 
@@ -97,7 +217,9 @@ func Indexes(m Model) map[string]interface{} {
 	return m.GetSchema().indexes
 }
 
-//ID returns the id of a given Model
+//ID returns the id of a given Model built from its schema. A record written to a block
+//rolled over under a BlockPolicy is stored under the id of the rolled over block e.g
+//Dataset/202003-1/Record instead of Dataset/202003/Record. Get, Exists and Delete accept either id
 func ID(m Model) string {
 	return m.GetSchema().recordID()
 }
@@ -126,12 +248,13 @@ var (
 	BlockByCount BlockMethod = "count"
 )
 
-//AutoBlock automatically generates block id for a given Model depending on a BlockMethod
+//AutoBlock automatically generates block id for a given Model depending on a BlockMethod.
+//It reads the blocks of the dataset on every call, BlockPolicy tracks block fill in memory instead
 func AutoBlock(dbPath string, m Model, method BlockMethod, n int64) string {
 
 	var currentBlock int
 	var currentBlockFile os.FileInfo
-	var currentBlockrecords map[string]interface{}
+	currentBlockrecords := &db.Block{}
 
 	//being sensible
 	if n <= 0 {
@@ -166,25 +289,14 @@ func AutoBlock(dbPath string, m Model, method BlockMethod, n int64) string {
 
 		currentBlock++
 		//TODO OPTIMIZE read file
-		b, err := ioutil.ReadFile(currentBlockFileName)
-		if err != nil {
-			log.Test("AutoBlock: " + err.Error())
-			log.Error(err.Error())
-			continue
-		}
-
-		currentBlockrecords = make(map[string]interface{})
-		if err := json.Unmarshal(b, &currentBlockrecords); err != nil {
-			log.Error(err.Error())
-			continue
-		}
+		currentBlockrecords = db.LoadBlock(currentBlockFileName, nil)
 
 		block := strings.Replace(filepath.Base(currentBlockFileName), filepath.Ext(currentBlockFileName), "", 1)
 		id := fmt.Sprintf("%s/%s/%s", dataset, block, m.GetSchema().record)
 
 		log.Test("AutoBlock: searching for  - " + id)
 		//model already exists return its block
-		if _, ok := currentBlockrecords[id]; ok {
+		if _, err := currentBlockrecords.Get(id); err == nil {
 			log.Test("AutoBlock: found - " + id)
 			return block
 		}
@@ -197,8 +309,8 @@ func AutoBlock(dbPath string, m Model, method BlockMethod, n int64) string {
 	}
 
 	//record size check
-	log.Test(fmt.Sprintf("AutoBlock: current block count - %d", len(currentBlockrecords)))
-	if method == BlockByCount && len(currentBlockrecords) >= int(n) {
+	log.Test(fmt.Sprintf("AutoBlock: current block count - %d", currentBlockrecords.Len()))
+	if method == BlockByCount && currentBlockrecords.Len() >= int(n) {
 		currentBlock++
 	}
 
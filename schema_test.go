@@ -86,6 +86,58 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateUniqueIndex(t *testing.T) {
+	indexes := map[string]interface{}{"Email": "alice@example.com"}
+	if err := gitdb.NewSchema("d1", "b0", "r0", indexes, gitdb.UniqueIndex("Email")).Validate(); err != nil {
+		t.Errorf("schema with unique index failed validation: %s", err)
+	}
+
+	if err := gitdb.NewSchema("d1", "b0", "r0", indexes, gitdb.UniqueIndex("Phone")).Validate(); err == nil {
+		t.Error("schema should fail validation if unique index is not an index")
+	}
+}
+
+func TestValidateFullText(t *testing.T) {
+	indexes := map[string]interface{}{"Notes": "late checkout"}
+	if err := gitdb.NewSchema("d1", "b0", "r0", indexes, gitdb.FullText("Notes")).Validate(); err != nil {
+		t.Errorf("schema with full-text index failed validation: %s", err)
+	}
+
+	if err := gitdb.NewSchema("d1", "b0", "r0", indexes, gitdb.FullText("Purpose")).Validate(); err == nil {
+		t.Error("schema should fail validation if full-text index is not an index")
+	}
+}
+
+func TestValidateBlockPolicy(t *testing.T) {
+	if err := gitdb.NewSchema("d1", "b0", "r0", nil, gitdb.BlockPolicy(gitdb.BlockBySize, 1<<20)).Validate(); err != nil {
+		t.Errorf("schema with block policy failed validation: %s", err)
+	}
+
+	if err := gitdb.NewSchema("d1", "b0", "r0", nil, gitdb.BlockPolicy("weekly", 10)).Validate(); err == nil {
+		t.Error("schema should fail validation with an invalid block method")
+	}
+}
+
+func TestValidateBlockFormat(t *testing.T) {
+	if err := gitdb.NewSchema("d1", "b0", "r0", nil, gitdb.StoreAs(gitdb.BlockFormatLines)).Validate(); err != nil {
+		t.Errorf("schema with block format failed validation: %s", err)
+	}
+
+	if err := gitdb.NewSchema("d1", "b0", "r0", nil, gitdb.StoreAs("yaml")).Validate(); err == nil {
+		t.Error("schema should fail validation with an invalid block format")
+	}
+}
+
+func TestValidateBlockCodec(t *testing.T) {
+	if err := gitdb.NewSchema("d1", "b0", "r0", nil, gitdb.CompressWith(gitdb.BlockCodecGzip)).Validate(); err != nil {
+		t.Errorf("schema with block codec failed validation: %s", err)
+	}
+
+	if err := gitdb.NewSchema("d1", "b0", "r0", nil, gitdb.CompressWith("lz4")).Validate(); err == nil {
+		t.Error("schema should fail validation with an invalid block codec")
+	}
+}
+
 func BenchmarkParseId(b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i <= b.N; i++ {
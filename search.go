@@ -0,0 +1,188 @@
+package gitdb
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//timeLayouts are the layouts gitdb will try when comparing index values as time
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+//match reports whether index value iv satisfies searchParam under searchMode
+func (p *SearchParam) match(iv interface{}, searchMode SearchMode) bool {
+	switch searchMode {
+	case SearchEquals:
+		return equalIndexValue(iv, p.Value)
+	case SearchNotEquals:
+		return !equalIndexValue(iv, p.Value)
+	case SearchContains:
+		return strings.Contains(lowerIndexValue(iv), strings.ToLower(p.Value))
+	case SearchStartsWith:
+		return strings.HasPrefix(lowerIndexValue(iv), strings.ToLower(p.Value))
+	case SearchEndsWith:
+		return strings.HasSuffix(lowerIndexValue(iv), strings.ToLower(p.Value))
+	case SearchGreaterThan:
+		c, ok := compareIndexValue(iv, p.Value)
+		return ok && c > 0
+	case SearchLessThan:
+		c, ok := compareIndexValue(iv, p.Value)
+		return ok && c < 0
+	case SearchBetween:
+		lo, ok := compareIndexValue(iv, p.Value)
+		if !ok || lo < 0 {
+			return false
+		}
+		hi, ok := compareIndexValue(iv, p.To)
+		return ok && hi <= 0
+	}
+
+	return false
+}
+
+//equalIndexValue compares iv and value by type falling back to
+//a case insensitive string comparison
+func equalIndexValue(iv interface{}, value string) bool {
+	if c, ok := compareIndexValue(iv, value); ok {
+		return c == 0
+	}
+	return lowerIndexValue(iv) == strings.ToLower(value)
+}
+
+//compareIndexValue compares index value iv to value using the type of iv.
+//numbers are compared as float64, strings that parse as time are compared
+//as time.Time and all other strings are compared case insensitively.
+//ok is false if value cannot be converted to the type of iv
+func compareIndexValue(iv interface{}, value string) (c int, ok bool) {
+	switch v := iv.(type) {
+	case float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0, false
+		}
+		return compareFloat(v, f), true
+	case string:
+		if t1, ok := parseTime(v); ok {
+			if t2, ok := parseTime(value); ok {
+				return compareTime(t1, t2), true
+			}
+		}
+		return strings.Compare(strings.ToLower(v), strings.ToLower(value)), true
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return 0, false
+		}
+		if v == b {
+			return 0, true
+		}
+		if v {
+			return 1, true
+		}
+		return -1, true
+	}
+
+	return 0, false
+}
+
+//compareIndexValues compares two index values. values of different
+//types are compared by their string representation
+func compareIndexValues(a, b interface{}) int {
+	switch v := a.(type) {
+	case float64:
+		if w, ok := b.(float64); ok {
+			return compareFloat(v, w)
+		}
+	case string:
+		if w, ok := b.(string); ok {
+			c, _ := compareIndexValue(v, w)
+			return c
+		}
+	case bool:
+		if w, ok := b.(bool); ok {
+			c, _ := compareIndexValue(v, strconv.FormatBool(w))
+			return c
+		}
+	}
+
+	return strings.Compare(indexValueString(a), indexValueString(b))
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func parseTime(value string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+//indexValueString returns the string representation of an index value
+func indexValueString(iv interface{}) string {
+	switch v := iv.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	b, err := json.Marshal(iv)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func lowerIndexValue(iv interface{}) string {
+	return strings.ToLower(indexValueString(iv))
+}
+
+//normalizeIndexValue converts an index value to the type it would have
+//after being written to and read back from an index file so that values
+//in the index cache can be compared consistently
+func normalizeIndexValue(iv interface{}) interface{} {
+	switch iv.(type) {
+	case nil, string, float64, bool:
+		return iv
+	}
+
+	b, err := json.Marshal(iv)
+	if err != nil {
+		return indexValueString(iv)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return indexValueString(iv)
+	}
+	return v
+}
@@ -1,13 +1,25 @@
 package gitdb
 
 import (
+	"context"
 	"fmt"
 	"github.com/bouggo/log"
+	"path/filepath"
 	"time"
 )
 
 func (g *gitdb) Sync() error {
-	g.syncMu.Lock()
+	return g.SyncContext(context.Background())
+}
+
+//SyncContext is Sync that gives up once ctx is done.
+//git processes still running when ctx is done are killed.
+//Only merging the fetched changes holds the connection so reads
+//and writes are not kept waiting while git talks to the online remote
+func (g *gitdb) SyncContext(ctx context.Context) error {
+	if err := lockContext(ctx, &g.syncMu); err != nil {
+		return err
+	}
 	defer g.syncMu.Unlock()
 
 	if len(g.config.OnlineRemote) == 0 {
@@ -20,34 +32,82 @@ func (g *gitdb) Sync() error {
 	}
 
 	log.Info("Syncing database...")
-	changedFiles := g.driver.changedFiles()
-	if err := g.driver.sync(); err != nil {
-		log.Error(err.Error())
-		return ErrDBSyncFailed
+	if err := g.driver.fetch(ctx); err != nil {
+		return syncError(ctx, err)
+	}
+
+	if err := g.mergeRemote(ctx); err != nil {
+		return syncError(ctx, err)
+	}
+
+	if err := g.driver.push(ctx); err != nil {
+		return syncError(ctx, err)
+	}
+
+	return nil
+}
+
+//syncError logs err and returns the error of ctx if it is done or else ErrDBSyncFailed
+func syncError(ctx context.Context, err error) error {
+	log.Error(err.Error())
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return ErrDBSyncFailed
+}
+
+//mergeRemote merges the changes fetched from the online remote
+//and reloads the caches of the files they change
+func (g *gitdb) mergeRemote(ctx context.Context) error {
+	unlock, err := g.lockConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	changedFiles := g.driver.changedFiles(ctx)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := g.driver.merge(ctx); err != nil {
+		return err
 	}
 
 	// reset loaded blocks
-	g.loadedBlocks = nil
+	g.blockCache.clear()
+	g.blockMu.Lock()
+	g.blockFills = nil
+	g.blockMu.Unlock()
+
+	blockFiles := make([]string, len(changedFiles))
+	for i, file := range changedFiles {
+		blockFiles[i] = filepath.Join(g.dbDir(), file)
+	}
+	g.refreshChecksums(blockFiles...)
 
 	g.buildIndexSmart(changedFiles)
 	return nil
 }
 
+//startSyncClock syncs the database every SyncInterval until the connection is closed.
+//Closing the connection cancels a sync in progress
 func (g *gitdb) startSyncClock() {
+	ctx, cancel := context.WithCancel(context.Background())
+	g.stopSync = cancel
 	go func(g *gitdb) {
 		log.Test(fmt.Sprintf("starting sync clock @ interval %s", g.config.SyncInterval))
 		ticker := time.NewTicker(g.config.SyncInterval)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-g.shutdown:
 				log.Test("shutting down sync clock")
 				return
 			case <-ticker.C:
-				g.writeMu.Lock()
-				if err := g.Sync(); err != nil {
+				if err := g.SyncContext(ctx); err != nil {
 					log.Error(err.Error())
 				}
-				g.writeMu.Unlock()
 			}
 		}
 	}(g)
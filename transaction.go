@@ -1,52 +1,123 @@
 package gitdb
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
+	"sync/atomic"
 
 	"github.com/bouggo/log"
 )
 
 type operation func() error
 
+type contextOperation func(ctx context.Context) error
+
 // Transaction represents a db transaction
 type Transaction interface {
 	Commit() error
 	AddOperation(o operation)
+	AddOperationContext(o contextOperation)
 }
 
 type transaction struct {
 	name       string
-	operations []operation
+	operations []contextOperation
 	db         *gitdb
+	//running is 1 while the operations of the transaction run
+	running int32
 }
 
+//txKey is the context key of the transaction whose operations a context is passed to
+type txKey struct{}
+
+//Commit runs the operations of the transaction and commits their writes once.
+//Transactions run one at a time and hold the connection's write lock until they
+//are done so other goroutines wait for them. Operations use the connection through
+//the Context methods with the ctx they are passed, which uses the lock the transaction holds
 func (t *transaction) Commit() error {
-	t.db.autoCommit = false
+	g := t.db
+	g.txMu.Lock()
+	defer g.txMu.Unlock()
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
+
+	g.begin()
+	ctx := context.WithValue(context.Background(), txKey{}, t)
+	atomic.StoreInt32(&t.running, 1)
+	defer atomic.StoreInt32(&t.running, 0)
+
 	for _, o := range t.operations {
-		if err := o(); err != nil {
-			log.Info("Reverting transaction: " + err.Error())
-			err2 := t.db.driver.undo()
-			t.db.autoCommit = true
-			if err2 != nil {
-				err = fmt.Errorf("%s - %s", err.Error(), err2.Error())
-			}
-
-			return err
+		if err := o(ctx); err != nil {
+			return g.rollback(err)
 		}
 	}
 
-	t.db.autoCommit = true
-	commitMsg := "Committing transaction: " + t.name
-	t.db.commit.Add(1)
-	t.db.events <- newWriteEvent(commitMsg, ".", t.db.autoCommit)
-	t.db.waitForCommit()
-	return nil
+	return g.end(context.Background(), t.name)
 }
 
+//AddOperation adds an operation that does not use the connection
 func (t *transaction) AddOperation(o operation) {
+	t.operations = append(t.operations, func(context.Context) error { return o() })
+}
+
+//AddOperationContext adds an operation that uses the connection through
+//the Context methods with ctx e.g InsertContext(ctx, m)
+func (t *transaction) AddOperationContext(o contextOperation) {
 	t.operations = append(t.operations, o)
 }
 
+//inTransaction reports whether ctx was passed to the operations of a running transaction of g
+func (g *gitdb) inTransaction(ctx context.Context) bool {
+	t, ok := ctx.Value(txKey{}).(*transaction)
+	return ok && t.db == g && atomic.LoadInt32(&t.running) == 1
+}
+
 func (g *gitdb) StartTransaction(name string) Transaction {
 	return &transaction{name: name, db: g}
 }
+
+//begin stops writes from being committed until end or rollback is called.
+//Caller must hold txMu and the write lock
+func (g *gitdb) begin() {
+	g.autoCommit = false
+	g.txDatasets = nil
+}
+
+//end commits all writes since begin. If ctx is done before the writes are
+//committed, they are committed in the background. Caller must hold txMu and the write lock
+func (g *gitdb) end(ctx context.Context, name string) error {
+	g.autoCommit = true
+	g.txDatasets = nil
+	commitMsg := "Committing transaction: " + name
+	return g.commitEvent(ctx, newWriteEvent(commitMsg, ".", g.autoCommit))
+}
+
+//rollback reverts all writes since begin and returns err.
+//Caller must hold txMu and the write lock
+func (g *gitdb) rollback(err error) error {
+	log.Info("Reverting transaction: " + err.Error())
+	err2 := g.driver.undo()
+	g.autoCommit = true
+	g.revert()
+	if err2 != nil {
+		err = fmt.Errorf("%s - %s", err.Error(), err2.Error())
+	}
+
+	return err
+}
+
+//revert discards cached blocks and rebuilds the indexes of
+//datasets written to by a reverted transaction
+func (g *gitdb) revert() {
+	g.blockCache.clear()
+	for dataset := range g.txDatasets {
+		blockFiles, _ := filepath.Glob(filepath.Join(g.dbDir(), dataset, "*.json"))
+		g.refreshChecksums(blockFiles...)
+		if _, err := g.checkIndex(dataset, true); err != nil {
+			log.Error(err.Error())
+		}
+		g.resetBlockFills(dataset)
+	}
+	g.txDatasets = nil
+}
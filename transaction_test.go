@@ -1,8 +1,10 @@
 package gitdb_test
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/gogitdb/gitdb/v2"
 )
@@ -25,3 +27,88 @@ func TestTransaction(t *testing.T) {
 	}
 
 }
+
+func TestTransactionWaitsForConcurrentWrites(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	//undo needs a repository with commits
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	inTx := getTestMessageWithId(2)
+	concurrent := getTestMessageWithId(3)
+
+	started := make(chan struct{})
+	inserted := make(chan error)
+	tx := testDb.StartTransaction("failing")
+	tx.AddOperationContext(func(ctx context.Context) error { return testDb.InsertContext(ctx, inTx) })
+	tx.AddOperationContext(func(ctx context.Context) error {
+		//operations can still read through the connection
+		if err := testDb.GetContext(ctx, gitdb.ID(inTx), &Message{}); err != nil {
+			return err
+		}
+
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return errors.New("test error")
+	})
+
+	go func() {
+		<-started
+		inserted <- testDb.Insert(concurrent)
+	}()
+
+	if err := tx.Commit(); err == nil || err.Error() != "test error" {
+		t.Errorf("tx.Commit want: test error, got: %v", err)
+	}
+
+	if err := <-inserted; err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//the concurrent insert is not part of the transaction and is not rolled back
+	if err := testDb.Get(gitdb.ID(concurrent), &Message{}); err != nil {
+		t.Errorf("concurrent insert lost: %s", err)
+	}
+
+	if err := testDb.Get(gitdb.ID(inTx), &Message{}); err == nil {
+		t.Error("insert of a failed transaction was not rolled back")
+	}
+}
+
+func TestTransactionInsertMany(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	//undo needs a repository with commits
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	inTx := []gitdb.Model{getTestMessageWithId(2), getTestMessageWithId(3)}
+	tx := testDb.StartTransaction("failing")
+	tx.AddOperationContext(func(ctx context.Context) error { return testDb.InsertManyContext(ctx, inTx) })
+	tx.AddOperation(func() error { return errors.New("test error") })
+	if err := tx.Commit(); err == nil || err.Error() != "test error" {
+		t.Errorf("tx.Commit want: test error, got: %v", err)
+	}
+
+	//models inserted by InsertManyContext are rolled back with the transaction
+	for _, m := range inTx {
+		if err := testDb.Exists(gitdb.ID(m)); err == nil {
+			t.Errorf("insert of a failed transaction was not rolled back: %s", gitdb.ID(m))
+		}
+	}
+
+	//blocks reverted by git have their checksums stored again
+	if report, err := testDb.Verify(); err != nil || report.Count(gitdb.SeverityError) > 0 {
+		t.Errorf("testDb.Verify want: no errors, got: %v (%v)", report.Problems, err)
+	}
+
+	//the connection is usable once the transaction is done
+	if err := testDb.InsertManyContext(context.Background(), inTx); err != nil {
+		t.Errorf("testDb.InsertManyContext failed: %s", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/db"
 	"github.com/gorilla/mux"
 )
@@ -29,7 +30,7 @@ func (g *gitdb) startUI() {
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf("localhost:%d", g.config.UIPort),
-		Handler: new(router).configure(g.config),
+		Handler: new(router).configure(g.config, g.keysFor),
 	}
 
 	log.Info("GitDB GUI will run at http://" + server.Addr)
@@ -83,7 +84,7 @@ type router struct {
 	refreshAt time.Time
 }
 
-func (u *router) configure(cfg Config) *mux.Router {
+func (u *router) configure(cfg Config, keys func(dataset string) *crypto.KeyRing) *mux.Router {
 	router := mux.NewRouter()
 	for path, handler := range u.getEndpoints() {
 		router.HandleFunc(path, handler)
@@ -92,7 +93,7 @@ func (u *router) configure(cfg Config) *mux.Router {
 	//refresh dataset after 1 minute
 	router.Use(func(h http.Handler) http.Handler {
 		if u.refreshAt.IsZero() || u.refreshAt.Before(time.Now()) {
-			u.datasets = db.LoadDatasets(filepath.Join(cfg.DBPath, "data"), cfg.EncryptionKey)
+			u.datasets = db.LoadDatasets(filepath.Join(cfg.DBPath, "data"), keys)
 			u.refreshAt = time.Now().Add(time.Second * 10)
 		}
 
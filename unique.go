@@ -0,0 +1,115 @@
+package gitdb
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+//uniqueValues maps the values of a cached unique index to the records holding
+//them so Insert finds the records holding a value without scanning the index
+type uniqueValues struct {
+	//index is the cached index the values were read from
+	index  gdbSimpleIndex
+	values map[string]map[string]bool //value => recordIDs
+}
+
+func newUniqueValues(index gdbSimpleIndex) *uniqueValues {
+	u := &uniqueValues{index: index, values: make(map[string]map[string]bool)}
+	for recordID, value := range index {
+		u.add(recordID, value)
+	}
+	return u
+}
+
+//uniqueKey returns the key of an index value. Strings equal to compareIndexValues
+//i.e the same time or the same text in another case have the same key
+func uniqueKey(value interface{}) string {
+	s, ok := value.(string)
+	if !ok {
+		return indexValueString(value)
+	}
+
+	if t, ok := parseTime(s); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return strings.ToLower(s)
+}
+
+func (u *uniqueValues) add(recordID string, value interface{}) {
+	key := uniqueKey(value)
+	if _, ok := u.values[key]; !ok {
+		u.values[key] = make(map[string]bool)
+	}
+	u.values[key][recordID] = true
+}
+
+func (u *uniqueValues) remove(recordID string, value interface{}) {
+	key := uniqueKey(value)
+	delete(u.values[key], recordID)
+	if len(u.values[key]) == 0 {
+		delete(u.values, key)
+	}
+}
+
+//holders returns the records holding value
+func (u *uniqueValues) holders(value interface{}) map[string]bool {
+	return u.values[uniqueKey(value)]
+}
+
+func sameIndex(a, b gdbSimpleIndex) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+//uniqueIndex returns the values of index, the index of indexFile. They are read from
+//index again once the cached index is replaced e.g by a rebuild. Caller must hold indexMu
+func (g *gitdb) uniqueIndex(indexFile string, index gdbSimpleIndex) *uniqueValues {
+	if u, ok := g.uniqueIndexes[indexFile]; ok && sameIndex(u.index, index) {
+		return u
+	}
+
+	u := newUniqueValues(index)
+	//values of indexes that are no longer cached are not kept
+	if cached, ok := g.indexCache[indexFile]; ok && sameIndex(cached, index) {
+		if g.uniqueIndexes == nil {
+			g.uniqueIndexes = make(map[string]*uniqueValues)
+		}
+		g.uniqueIndexes[indexFile] = u
+	}
+	return u
+}
+
+//updateUniqueIndexes updates the values of unique indexes for records whose values changed in
+//the index cache. replaced holds the values they held before (indexFile => (recordID => value)).
+//Caller must hold indexMu
+func (g *gitdb) updateUniqueIndexes(replaced gdbSimpleIndexCache) {
+	for indexFile, records := range replaced {
+		u, ok := g.uniqueIndexes[indexFile]
+		if !ok {
+			continue
+		}
+
+		for recordID, old := range records {
+			u.remove(recordID, old)
+			if value, ok := u.index[recordID]; ok {
+				u.add(recordID, value)
+			}
+		}
+	}
+}
+
+//removeFromUniqueIndexes removes records from the unique indexes of a dataset. It must be called
+//before the records are removed from the index cache. Caller must hold indexMu
+func (g *gitdb) removeFromUniqueIndexes(dataset string, recordIDs ...string) {
+	for indexFile, u := range g.uniqueIndexes {
+		if !g.isDatasetIndex(indexFile, dataset) {
+			continue
+		}
+
+		for _, recordID := range recordIDs {
+			if value, ok := u.index[recordID]; ok {
+				u.remove(recordID, value)
+			}
+		}
+	}
+}
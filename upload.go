@@ -151,7 +151,7 @@ func (u *Upload) upload(bucket, file string) error {
 		Bucket:     bucket,
 		File:       filename,
 		Path:       uploadPath,
-		UploadedBy: u.db.config.User.String(),
+		UploadedBy: u.db.Config().User.String(),
 	}
 	return u.db.Insert(m)
 }
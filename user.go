@@ -24,6 +24,9 @@ func NewUser(name string, email string) *User {
 
 //SetUser sets the user connection
 func (g *gitdb) SetUser(user *User) error {
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
+
 	g.config.User = user
 	return nil
 }
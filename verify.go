@@ -0,0 +1,374 @@
+package gitdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+var (
+	errRecordNoKey = errors.New("record is encrypted but Config.EncryptionKey is not set")
+	errRecordKey   = errors.New("record cannot be decrypted with Config.EncryptionKey or was tampered with")
+)
+
+//Severity is how serious a problem found by Verify is
+type Severity string
+
+const (
+	//SeverityInfo is a problem that does not affect data e.g a dataset that could not be fully checked
+	SeverityInfo Severity = "info"
+	//SeverityWarning is a problem that can be fixed without losing data e.g a stale index
+	SeverityWarning Severity = "warning"
+	//SeverityError is a problem that makes data unreadable or untrustworthy e.g a block changed outside gitdb
+	SeverityError Severity = "error"
+)
+
+//Problem is a problem found by Verify
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Dataset  string   `json:"dataset"`
+	Block    string   `json:"block,omitempty"`
+	Record   string   `json:"record,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (p *Problem) String() string {
+	location := p.Dataset
+	if len(p.Block) > 0 {
+		location += "/" + p.Block
+	}
+	if len(p.Record) > 0 {
+		location = p.Record
+	}
+	return fmt.Sprintf("[%s] %s: %s", p.Severity, location, p.Message)
+}
+
+//BlockReport describes a block checked by Verify
+type BlockReport struct {
+	Dataset string `json:"dataset"`
+	Block   string `json:"block"`
+	Records int    `json:"records"`
+	Size    int64  `json:"size"`
+	//Checksum is the hex encoded SHA-256 of the block file. Verify reports an error if it
+	//differs from the checksum stored when gitdb last changed the block
+	Checksum string `json:"checksum"`
+}
+
+//VerifyReport is the result of Verify
+type VerifyReport struct {
+	Datasets int            `json:"datasets"`
+	Records  int            `json:"records"`
+	Blocks   []*BlockReport `json:"blocks"`
+	Problems []*Problem     `json:"problems"`
+}
+
+//Count returns the number of problems of severity found
+func (r *VerifyReport) Count(severity Severity) int {
+	count := 0
+	for _, p := range r.Problems {
+		if p.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+//Healthy reports whether no errors were found
+func (r *VerifyReport) Healthy() bool {
+	return r.Count(SeverityError) == 0
+}
+
+func (r *VerifyReport) add(severity Severity, dataset, block, record, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, &Problem{
+		Severity: severity,
+		Dataset:  dataset,
+		Block:    block,
+		Record:   record,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+//Verify checks every dataset in the database and reports the problems found.
+//Blocks must be complete block files holding records of a known version in the block
+//named by their id, encrypted records must decrypt with Config.EncryptionKey, indexes of
+//registered datasets must match their blocks and datasets without records must not hold locks
+func (g *gitdb) Verify() (*VerifyReport, error) {
+	g.connMu.RLock()
+	defer g.connMu.RUnlock()
+
+	report := &VerifyReport{Blocks: []*BlockReport{}, Problems: []*Problem{}}
+	for _, dataset := range g.datasetNames() {
+		if err := g.waitForIndex(context.Background(), dataset); err != nil {
+			return nil, err
+		}
+
+		if err := g.verifyDataset(report, dataset); err != nil {
+			return nil, err
+		}
+		report.Datasets++
+	}
+
+	return report, nil
+}
+
+//VerifyDB checks the database of cfg like GitDb.Verify without opening a connection, so
+//nothing in the database is changed: files left by a crash are reported instead of being
+//recovered and no index is built. Datasets are checked with the models of cfg.Factory and
+//the indexes of datasets without a model are only checked against the record ids of their blocks
+func VerifyDB(cfg *Config) (*VerifyReport, error) {
+	c := *cfg
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(c.DBPath, "data")); err != nil {
+		return nil, err
+	}
+
+	g := newConnection()
+	g.configure(c)
+	g.readOnly = true
+	if err := g.resolveKey(); err != nil {
+		return nil, err
+	}
+
+	return g.Verify()
+}
+
+func (g *gitdb) verifyDataset(report *VerifyReport, dataset string) error {
+	datasetPath := filepath.Join(g.dbDir(), dataset)
+	files, err := ioutil.ReadDir(datasetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	recordIDs := map[string]bool{}
+	for _, file := range files {
+		path := filepath.Join(datasetPath, file.Name())
+		switch {
+		case file.IsDir() && file.Name() == "Lock":
+			continue
+		case isTempFile(path):
+			report.add(SeverityWarning, dataset, "", "", "partially written file %s", file.Name())
+		case filepath.Ext(path) == ".json":
+			if err := g.verifyBlock(report, dataset, path, recordIDs); err != nil {
+				return err
+			}
+		}
+	}
+	records := len(recordIDs)
+	report.Records += records
+
+	if g.isRegistered(dataset) {
+		drift, err := g.checkIndex(dataset, false)
+		if err != nil {
+			return err
+		}
+
+		for _, recordID := range drift.Missing {
+			report.add(SeverityWarning, dataset, "", recordID, "record is missing from the index")
+		}
+		for _, recordID := range drift.Stale {
+			report.add(SeverityWarning, dataset, "", recordID, "index holds a record that does not exist")
+		}
+		for _, recordID := range drift.Changed {
+			report.add(SeverityWarning, dataset, "", recordID, "index values differ from the record")
+		}
+	} else {
+		g.verifyIndexFiles(report, dataset, recordIDs)
+	}
+
+	locks, _ := filepath.Glob(filepath.Join(datasetPath, "Lock", "*.lock"))
+	if records == 0 {
+		for _, lock := range locks {
+			report.add(SeverityWarning, dataset, "", "", "orphaned lock file %s in dataset without records", filepath.Base(lock))
+		}
+	}
+
+	return nil
+}
+
+//verifyBlock checks the block file at path and adds the ids of the records it holds to recordIDs
+func (g *gitdb) verifyBlock(report *VerifyReport, dataset, path string, recordIDs map[string]bool) error {
+	block := strings.TrimSuffix(filepath.Base(path), ".json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	blockReport := &BlockReport{Dataset: dataset, Block: block, Size: int64(len(data)), Checksum: checksum(data)}
+	report.Blocks = append(report.Blocks, blockReport)
+
+	if stored := g.blockChecksum(path); len(stored) > 0 && stored != blockReport.Checksum {
+		report.add(SeverityError, dataset, block, "", "block was changed outside gitdb: checksum %s does not match %s stored when it was written", blockReport.Checksum, stored)
+	}
+
+	dataBlock, err := db.ParseBlock(data)
+	if err != nil {
+		report.add(SeverityError, dataset, block, "", "unreadable block: %s", err)
+		return nil
+	}
+
+	for _, record := range dataBlock.Records() {
+		blockReport.Records++
+		recordIDs[record.ID()] = true
+		recordDataset, recordBlock, _, err := ParseID(record.ID())
+		if err != nil || recordDataset != dataset || recordBlock != block {
+			report.add(SeverityError, dataset, block, record.ID(), "record id does not belong in this block")
+		}
+
+		if err := g.verifyRecord(record.Data(), g.keysFor(dataset)); err != nil {
+			report.add(SeverityError, dataset, block, record.ID(), "%s", err)
+		}
+	}
+
+	return nil
+}
+
+//verifyIndexFiles checks the index files of a dataset that is not registered. Index values
+//cannot be checked without the model of the dataset so only the structure of the index files
+//and the records they hold are checked
+func (g *gitdb) verifyIndexFiles(report *VerifyReport, dataset string, recordIDs map[string]bool) {
+	files, err := ioutil.ReadDir(g.indexPath(dataset))
+	if err != nil {
+		if len(recordIDs) > 0 {
+			report.add(SeverityWarning, dataset, "", "", "dataset has no index files")
+		}
+		return
+	}
+
+	for _, file := range files {
+		path := filepath.Join(g.indexPath(dataset), file.Name())
+		if isTempFile(path) {
+			report.add(SeverityWarning, dataset, "", "", "partially written index file %s", file.Name())
+			continue
+		}
+
+		index, err := g.readIndexFile(path)
+		switch {
+		case err != nil && g.keysFor(dataset).Empty() && isSealedIndexFile(path):
+			report.add(SeverityInfo, dataset, "", "", "index file %s is sealed and Config.EncryptionKey is not set", file.Name())
+		case err != nil:
+			report.add(SeverityWarning, dataset, "", "", "unreadable index file %s: %s", file.Name(), err)
+		case index != nil && indexName(path) == "id":
+			for _, recordID := range sortedIDs(recordIDs) {
+				if _, ok := index[recordID]; !ok {
+					report.add(SeverityWarning, dataset, "", recordID, "record is missing from the index")
+				}
+			}
+			for _, recordID := range sortedKeys(index) {
+				if !recordIDs[recordID] {
+					report.add(SeverityWarning, dataset, "", recordID, "index holds a record that does not exist")
+				}
+			}
+		case index != nil:
+			missing, stale := 0, 0
+			for recordID := range recordIDs {
+				if _, ok := index[recordID]; !ok {
+					missing++
+				}
+			}
+			for recordID := range index {
+				if !recordIDs[recordID] {
+					stale++
+				}
+			}
+			if missing > 0 || stale > 0 {
+				report.add(SeverityWarning, dataset, "", "", "index %s is missing %d records and holds %d records that do not exist", indexName(path), missing, stale)
+			}
+		}
+	}
+
+	report.add(SeverityInfo, dataset, "", "", "dataset is not registered so index values were not checked")
+}
+
+//readIndexFile reads the index file at path without caching it. The index of
+//text index files is not returned as it is only checked to be readable
+func (g *gitdb) readIndexFile(path string) (gdbSimpleIndex, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = openIndex(data, g.keysFor(filepath.Base(filepath.Dir(path)))); err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(path) {
+	case ".idx":
+		return decodeIndex(data)
+	case ".fts":
+		_, err = decodeTextIndex(data)
+		return nil, err
+	case ".json":
+		index := gdbSimpleIndex{}
+		return index, json.Unmarshal(data, &index)
+	}
+
+	return nil, nil
+}
+
+func isSealedIndexFile(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	return err == nil && isSealedIndex(data)
+}
+
+func sortedIDs(ids map[string]bool) []string {
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+//verifyRecord returns what is wrong with the data of a record encrypted with keys
+func (g *gitdb) verifyRecord(data string, keys *crypto.KeyRing) error {
+	if !json.Valid([]byte(data)) {
+		if keys.Empty() {
+			return errRecordNoKey
+		}
+
+		dec, err := keys.Decrypt(data)
+		if err != nil {
+			return errRecordKey
+		}
+		data = dec
+	}
+
+	if _, err := db.DecryptFields(data, keys); err != nil {
+		if keys.Empty() {
+			return errRecordNoKey
+		}
+		return errRecordKey
+	}
+
+	var record struct {
+		Version string
+		Data    json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return errors.New("record is not a JSON object")
+	}
+
+	switch record.Version {
+	case "", "v1":
+	case RecVersion:
+		if !strings.HasPrefix(strings.TrimSpace(string(record.Data)), "{") {
+			return errors.New("record has no Data object")
+		}
+	default:
+		return errors.New("unknown record version " + record.Version)
+	}
+
+	return nil
+}
@@ -1,18 +1,34 @@
 package gitdb
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/bouggo/log"
-	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
 func (g *gitdb) Insert(mo Model) error {
+	return g.InsertContext(context.Background(), mo)
+}
+
+//InsertContext inserts mo unless ctx is done first. If ctx is done after the record
+//was written, the error of ctx is returned and the record is committed in the background
+func (g *gitdb) InsertContext(ctx context.Context, mo Model) error {
+	unlock, err := g.lockConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return g.insertModel(ctx, mo)
+}
+
+func (g *gitdb) insertModel(ctx context.Context, mo Model) error {
 	m := wrap(mo)
 
 	if err := m.Validate(); err != nil {
@@ -27,23 +43,51 @@ func (g *gitdb) Insert(mo Model) error {
 		return err
 	}
 
-	return g.insert(m)
+	return g.insert(ctx, m)
 }
 
 func (g *gitdb) InsertMany(models []Model) error {
-	tx := g.StartTransaction("InsertMany")
-	for _, model := range models {
-		//create a new variable to pass to function to avoid
-		//passing pointer which will end up inserting the same
-		//model multiple times
-		m := model
-		f := func() error { return g.Insert(m) }
-		tx.AddOperation(f)
+	return g.InsertManyContext(context.Background(), models)
+}
+
+//InsertManyContext inserts models unless ctx is done first. Models inserted
+//before ctx is done are rolled back. In a transaction the models become part of it
+func (g *gitdb) InsertManyContext(ctx context.Context, models []Model) error {
+	if g.inTransaction(ctx) {
+		for _, m := range models {
+			if err := g.insertModel(ctx, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := lockContext(ctx, &g.txMu); err != nil {
+		return err
+	}
+	defer g.txMu.Unlock()
+	unlock, err := g.lockConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return g.insertMany(ctx, models)
+}
+
+//insertMany inserts models in a transaction. Caller must hold txMu and the write lock
+func (g *gitdb) insertMany(ctx context.Context, models []Model) error {
+	g.begin()
+	for _, m := range models {
+		if err := g.insertModel(ctx, m); err != nil {
+			return g.rollback(err)
+		}
 	}
-	return tx.Commit()
+
+	return g.end(ctx, "InsertMany")
 }
 
-func (g *gitdb) insert(m Model) error {
+func (g *gitdb) insert(ctx context.Context, m Model) error {
 	if !g.isRegistered(m.GetSchema().dataset) {
 		return ErrInvalidDataset
 	}
@@ -55,8 +99,19 @@ func (g *gitdb) insert(m Model) error {
 		}
 	}
 
+	if err := g.waitForIndex(ctx, m.GetSchema().name()); err != nil {
+		return err
+	}
+
 	schema := m.GetSchema()
-	blockFilePath := g.blockFilePath(schema.name(), schema.block)
+	block := g.blockFor(m)
+	mID := schema.name() + "/" + block + "/" + schema.record
+
+	if err := g.checkUnique(m, mID); err != nil {
+		return err
+	}
+
+	blockFilePath := g.blockFilePath(schema.name(), block)
 	dataBlock, err := g.loadBlock(blockFilePath)
 	if err != nil {
 		return err
@@ -70,44 +125,79 @@ func (g *gitdb) insert(m Model) error {
 		return err
 	}
 
-	mID := ID(m)
-
 	//construct a commit message
 	commitMsg := "Inserting " + mID
 	if _, err := dataBlock.Get(mID); err == nil {
 		commitMsg = "Updating " + mID
 	}
 
-	newRecordStr := string(newRecordBytes)
 	//encrypt data if need be
-	if m.ShouldEncrypt() {
-		newRecordStr = crypto.Encrypt(g.config.EncryptionKey, newRecordStr)
+	newRecordStr, err := g.encryptRecord(m, string(newRecordBytes))
+	if err != nil {
+		return err
+	}
+
+	//last chance to give up before the cached block is changed and written
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	dataBlock.Add(mID, newRecordStr)
 
-	g.events <- newWriteBeforeEvent("...", mID)
+	g.notify(ctx, newWriteBeforeEvent("...", mID))
 	if err := g.writeBlock(blockFilePath, dataBlock); err != nil {
 		return err
 	}
 
+	if info, err := os.Stat(blockFilePath); err == nil {
+		g.updateBlockFill(schema, block, dataBlock.Len(), info.Size())
+	}
+
 	log.Info(fmt.Sprintf("autoCommit: %v", g.autoCommit))
 
-	g.commit.Add(1)
-	g.events <- newWriteEvent(commitMsg, blockFilePath, g.autoCommit)
-	log.Test("sent write event to loop")
+	g.touch(schema.name())
 	g.updateIndexes(dataBlock)
 
 	//block here until write has been committed
-	g.waitForCommit()
+	return g.commitEvent(ctx, newWriteEvent(commitMsg, blockFilePath, g.autoCommit))
+}
+
+//checkUnique ensures no record other than mID holds the value of a unique index of m
+func (g *gitdb) checkUnique(m Model, mID string) error {
+	schema := m.GetSchema()
+	for name := range schema.unique {
+		value := normalizeIndexValue(schema.indexes[name])
+		if value == nil || value == "" {
+			continue
+		}
+
+		index := g.loadIndex(schema.name(), name)
+		var holder string
+		g.indexMu.Lock()
+		for recordID := range g.uniqueIndex(g.indexFilePath(schema.name(), name), index).holders(value) {
+			if recordID != mID {
+				holder = recordID
+				break
+			}
+		}
+		g.indexMu.Unlock()
+
+		if len(holder) > 0 {
+			return &UniqueViolationError{Index: name, Value: value, RecordID: holder}
+		}
+	}
 
 	return nil
 }
 
-func (g *gitdb) waitForCommit() {
-	if g.autoCommit {
-		log.Test("waiting for gitdb to commit changes")
-		g.commit.Wait()
+//touch records datasets written to during a transaction
+//so their indexes can be rebuilt if the transaction is reverted
+func (g *gitdb) touch(dataset string) {
+	if !g.autoCommit {
+		if g.txDatasets == nil {
+			g.txDatasets = make(map[string]bool)
+		}
+		g.txDatasets[dataset] = true
 	}
 }
 
@@ -115,41 +205,102 @@ func (g *gitdb) writeBlock(blockFile string, block *db.Block) error {
 	g.writeMu.Lock()
 	defer g.writeMu.Unlock()
 
-	blockBytes, fmtErr := json.MarshalIndent(block, "", "\t")
+	dataset := filepath.Base(filepath.Dir(blockFile))
+	blockBytes, fmtErr := block.Encode(db.Format(g.blockFormat(dataset)))
+	if fmtErr == nil {
+		blockBytes, fmtErr = db.Compress(db.Codec(g.blockCodec(dataset)), blockBytes)
+	}
+
 	if fmtErr != nil {
+		g.blockCache.remove(blockFile)
 		return fmtErr
 	}
 
-	//update cache
-	if g.loadedBlocks != nil {
-		g.loadedBlocks[blockFile] = block
+	if err := writeFileAtomic(blockFile, blockBytes, 0744); err != nil {
+		//block may hold changes that are not on disk
+		g.blockCache.remove(blockFile)
+		return err
+	}
+	g.cacheBlock(blockFile, block)
+
+	g.savePositions(blockFile, blockBytes, checksum(blockBytes))
+	return nil
+}
+
+//blockFormat returns the format blocks of dataset are written in
+func (g *gitdb) blockFormat(dataset string) BlockFormat {
+	if m := g.modelFor(dataset); m != nil && len(m.GetSchema().format) > 0 {
+		return m.GetSchema().format
+	}
+
+	if len(g.config.BlockFormat) > 0 {
+		return g.config.BlockFormat
+	}
+
+	return BlockFormatJSON
+}
+
+//blockCodec returns the codec blocks of dataset are compressed with
+func (g *gitdb) blockCodec(dataset string) BlockCodec {
+	if m := g.modelFor(dataset); m != nil && len(m.GetSchema().codec) > 0 {
+		return m.GetSchema().codec
+	}
+
+	if len(g.config.BlockCodec) > 0 {
+		return g.config.BlockCodec
 	}
-	return ioutil.WriteFile(blockFile, blockBytes, 0744)
+
+	return BlockCodecNone
 }
 
 func (g *gitdb) Delete(id string) error {
-	return g.doDelete(id, false)
+	return g.DeleteContext(context.Background(), id)
+}
+
+//DeleteContext deletes the record with id unless ctx is done first
+func (g *gitdb) DeleteContext(ctx context.Context, id string) error {
+	unlock, err := g.lockConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return g.doDelete(ctx, id, false)
 }
 
 func (g *gitdb) DeleteOrFail(id string) error {
-	return g.doDelete(id, true)
+	g.connMu.Lock()
+	defer g.connMu.Unlock()
+
+	return g.doDelete(context.Background(), id, true)
 }
 
-func (g *gitdb) doDelete(id string, failNotFound bool) error {
+func (g *gitdb) doDelete(ctx context.Context, id string, failNotFound bool) error {
 
+	id = g.resolveID(id)
 	dataset, block, _, err := ParseID(id)
 	if err != nil {
 		return err
 	}
 
+	if err := g.waitForIndex(ctx, dataset); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	blockFilePath := g.blockFilePath(dataset, block)
 	err = g.delByID(id, blockFilePath, failNotFound)
 
 	if err == nil {
+		g.removeFromIndexes(dataset, id)
+		g.resetBlockFills(dataset)
+		g.touch(dataset)
+
 		log.Test("sending delete event to loop")
-		g.commit.Add(1)
-		g.events <- newDeleteEvent(fmt.Sprintf("Deleting %s", id), blockFilePath, g.autoCommit)
-		g.waitForCommit()
+		err = g.commitEvent(ctx, newDeleteEvent(fmt.Sprintf("Deleting %s", id), blockFilePath, g.autoCommit))
 	}
 
 	return err
@@ -164,7 +315,11 @@ func (g *gitdb) delByID(id string, blockFile string, failIfNotFound bool) error
 		return nil
 	}
 
-	dataBlock := db.LoadBlock(blockFile, g.config.EncryptionKey)
+	dataBlock, err := g.loadBlock(blockFile)
+	if err != nil {
+		return err
+	}
+
 	if err := dataBlock.Delete(id); err != nil {
 		if failIfNotFound {
 			return errors.New("Could not delete [" + id + "]: record does not exist")
@@ -1,7 +1,15 @@
 package gitdb_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gogitdb/gitdb/v2"
 )
@@ -61,6 +69,389 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestDeleteUpdatesIndex(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	generateInserts(t, 3)
+	if err := testDb.Delete("Message/b0/1"); err != nil {
+		t.Errorf("testDb.Delete failed: %s", err)
+	}
+
+	results, err := testDb.Search("Message", []*gitdb.SearchParam{{Index: "MessageId", Value: "1"}}, gitdb.SearchEquals)
+	if err != nil {
+		t.Errorf("testDb.Search failed: %s", err)
+	}
+
+	if len(results) != 0 {
+		t.Errorf("deleted record found in index. want: %d, got: %d", 0, len(results))
+	}
+
+	count, err := testDb.Count("Message", gitdb.All())
+	if err != nil {
+		t.Errorf("testDb.Count failed: %s", err)
+	}
+
+	if count != 2 {
+		t.Errorf("testDb.Count want: %d, got: %d", 2, count)
+	}
+}
+
+func TestCheckIndex(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	generateInserts(t, 2)
+	drift, err := testDb.CheckIndex("Message", false)
+	if err != nil {
+		t.Errorf("testDb.CheckIndex failed: %s", err)
+	}
+
+	if !drift.InSync() {
+		t.Errorf("index should be in sync: %+v", drift)
+	}
+
+	//remove block behind gitdb's back
+	if err := os.Remove(filepath.Join(dbPath, "data", "Message", "b0.json")); err != nil {
+		t.Fatalf("os.Remove failed: %s", err)
+	}
+
+	drift, err = testDb.CheckIndex("Message", true)
+	if err != nil {
+		t.Errorf("testDb.CheckIndex failed: %s", err)
+	}
+
+	if len(drift.Stale) != 2 || !drift.Repaired {
+		t.Errorf("testDb.CheckIndex want 2 stale records repaired, got: %+v", drift)
+	}
+
+	if drift, _ = testDb.CheckIndex("Message", false); !drift.InSync() {
+		t.Errorf("index should be in sync after repair: %+v", drift)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	generateInserts(t, 3)
+	status := getIndexStatus(t, "Message")
+	if status.Records != 3 || !status.Fresh || status.Building {
+		t.Errorf("want fresh index of 3 records, got: %+v", status)
+	}
+
+	//change block behind gitdb's back
+	blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(blockFile, later, later); err != nil {
+		t.Fatalf("os.Chtimes failed: %s", err)
+	}
+
+	if status := getIndexStatus(t, "Message"); status.Fresh {
+		t.Errorf("index should not be fresh after block changed: %+v", status)
+	}
+
+	if err := testDb.Reindex("Message"); err != nil {
+		t.Errorf("testDb.Reindex failed: %s", err)
+	}
+
+	//block was changed in the future so compare records only
+	if status := getIndexStatus(t, "Message"); status.Records != 3 {
+		t.Errorf("want 3 records after reindex, got: %+v", status)
+	}
+
+	if err := testDb.Reindex("Unknown"); !errors.Is(err, gitdb.ErrInvalidDataset) {
+		t.Errorf("testDb.Reindex want ErrInvalidDataset, got: %v", err)
+	}
+}
+
+func getIndexStatus(t *testing.T, dataset string) *gitdb.IndexStatus {
+	statuses, err := testDb.IndexStatus()
+	if err != nil {
+		t.Fatalf("testDb.IndexStatus failed: %s", err)
+	}
+
+	for _, status := range statuses {
+		if status.Dataset == dataset {
+			return status
+		}
+	}
+
+	t.Fatalf("testDb.IndexStatus has no status for %s", dataset)
+	return nil
+}
+
+func TestLegacyJSONIndex(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	generateInserts(t, 2)
+	testDb.Close()
+
+	//replace index files with json index files written by older versions
+	indexPath := filepath.Join(dbPath, ".gitdb", "index", "Message")
+	if err := os.RemoveAll(indexPath); err != nil {
+		t.Fatalf("os.RemoveAll failed: %s", err)
+	}
+
+	legacy := map[string]string{
+		"id.json":        `{"Message/b0/0":"Message/b0/0","Message/b0/1":"Message/b0/1"}`,
+		"MessageId.json": `{"Message/b0/0":0,"Message/b0/1":42}`,
+	}
+	if err := os.MkdirAll(indexPath, 0755); err != nil {
+		t.Fatalf("os.MkdirAll failed: %s", err)
+	}
+	for name, data := range legacy {
+		if err := ioutil.WriteFile(filepath.Join(indexPath, name), []byte(data), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %s", err)
+		}
+	}
+
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Message", &Message{})
+
+	count, err := testDb.Count("Message", gitdb.Where("MessageId", gitdb.SearchEquals, "42"))
+	if err != nil || count != 1 {
+		t.Errorf("json index file not read. want: 1, got: %d (%v)", count, err)
+	}
+
+	if err := testDb.Insert(getTestMessageWithId(2)); err != nil {
+		t.Errorf("testDb.Insert failed: %s", err)
+	}
+	testDb.Close()
+
+	for name := range legacy {
+		if _, err := os.Stat(filepath.Join(indexPath, name)); !os.IsNotExist(err) {
+			t.Errorf("json index file %s should be replaced on flush", name)
+		}
+	}
+
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Message", &Message{})
+	if count, _ := testDb.Count("Message", gitdb.All()); count != 3 {
+		t.Errorf("testDb.Count after flush want: %d, got: %d", 3, count)
+	}
+}
+
+func TestInsertBlockPolicy(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("LogEntry", &LogEntry{})
+
+	for i := 1; i <= 5; i++ {
+		if err := testDb.Insert(&LogEntry{EntryId: i, Text: fmt.Sprintf("entry %d", i)}); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//updates stay in the block the record is in
+	if err := testDb.Insert(&LogEntry{EntryId: 1, Text: "entry one"}); err != nil {
+		t.Errorf("testDb.Insert update failed: %s", err)
+	}
+
+	for block, want := range map[string]int{"2020": 2, "2020~1": 2, "2020~2": 1} {
+		records, err := testDb.Fetch("LogEntry", block)
+		if err != nil || len(records) != want {
+			t.Errorf("block %s want: %d records, got: %d (%v)", block, want, len(records), err)
+		}
+	}
+
+	//records are found by the id of their schema
+	entry := &LogEntry{}
+	if err := testDb.Get(gitdb.ID(&LogEntry{EntryId: 3}), entry); err != nil || entry.Text != "entry 3" {
+		t.Errorf("testDb.Get want: %s, got: %s (%v)", "entry 3", entry.Text, err)
+	}
+
+	if err := testDb.Get("LogEntry/2020~1/3", entry); err != nil {
+		t.Errorf("testDb.Get by block failed: %s", err)
+	}
+
+	if err := testDb.DeleteOrFail(gitdb.ID(&LogEntry{EntryId: 5})); err != nil {
+		t.Errorf("testDb.DeleteOrFail failed: %s", err)
+	}
+
+	//fill of blocks is read from disk by a new connection
+	testDb.Close()
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("LogEntry", &LogEntry{})
+
+	if err := testDb.Insert(&LogEntry{EntryId: 6, Text: "entry 6"}); err != nil {
+		t.Errorf("testDb.Insert failed: %s", err)
+	}
+
+	if err := testDb.Exists("LogEntry/2020~2/6"); err != nil {
+		t.Errorf("record should be written to the current block: %s", err)
+	}
+
+	//records of rolled over blocks are found and updated where they are by a new connection
+	if err := testDb.Insert(&LogEntry{EntryId: 3, Text: "entry three"}); err != nil {
+		t.Errorf("testDb.Insert update failed: %s", err)
+	}
+
+	if err := testDb.Get(gitdb.ID(&LogEntry{EntryId: 3}), entry); err != nil || entry.Text != "entry three" {
+		t.Errorf("testDb.Get want: %s, got: %s (%v)", "entry three", entry.Text, err)
+	}
+
+	if err := testDb.Exists("LogEntry/2020~1/3"); err != nil {
+		t.Errorf("updated record should stay in its block: %s", err)
+	}
+}
+
+//datedEntry is a LogEntry written to the block named by Block
+type datedEntry struct {
+	LogEntry
+	Block string
+}
+
+func (e *datedEntry) GetSchema() *gitdb.Schema {
+	indexes := map[string]interface{}{"Text": e.Text}
+	return gitdb.NewSchema("LogEntry", e.Block, fmt.Sprintf("%d", e.EntryId), indexes, gitdb.BlockPolicy(gitdb.BlockByCount, 2))
+}
+
+func TestInsertBlockPolicyBlockNames(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("LogEntry", &datedEntry{})
+
+	//2020-3 is a schema block of its own and not rolled over from 2020
+	if err := testDb.Insert(&datedEntry{LogEntry{EntryId: 1, Text: "march"}, "2020-3"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	testDb.Close()
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("LogEntry", &datedEntry{})
+
+	if err := testDb.Insert(&datedEntry{LogEntry{EntryId: 2, Text: "year"}, "2020"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	for block, want := range map[string]int{"2020": 1, "2020-3": 1} {
+		records, err := testDb.Fetch("LogEntry", block)
+		if err != nil || len(records) != want {
+			t.Errorf("block %s want: %d records, got: %d (%v)", block, want, len(records), err)
+		}
+	}
+
+	//schema blocks cannot be named like rolled over blocks
+	if err := testDb.Insert(&datedEntry{LogEntry{EntryId: 3, Text: "rolled"}, "2020~1"}); err == nil {
+		t.Error("testDb.Insert should fail if the schema block is named like a rolled over block")
+	}
+}
+
+func TestInsertUniqueIndex(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Customer", &Customer{})
+
+	if err := testDb.Insert(&Customer{CustomerId: 1, Email: "alice@example.com"}); err != nil {
+		t.Errorf("testDb.Insert failed: %s", err)
+	}
+
+	//updating a record keeps its own value
+	if err := testDb.Insert(&Customer{CustomerId: 1, Email: "alice@example.com"}); err != nil {
+		t.Errorf("testDb.Insert update failed: %s", err)
+	}
+
+	err := testDb.Insert(&Customer{CustomerId: 2, Email: "ALICE@example.com"})
+	var violation *gitdb.UniqueViolationError
+	if !errors.Is(err, gitdb.ErrUniqueViolation) || !errors.As(err, &violation) {
+		t.Fatalf("testDb.Insert want ErrUniqueViolation, got: %v", err)
+	}
+
+	if violation.Index != "Email" || violation.RecordID != "Customer/b0/1" {
+		t.Errorf("want violation of Email by Customer/b0/1, got: %s by %s", violation.Index, violation.RecordID)
+	}
+
+	//duplicates within a transaction are rejected
+	err = testDb.InsertMany([]gitdb.Model{
+		&Customer{CustomerId: 3, Email: "bob@example.com"},
+		&Customer{CustomerId: 4, Email: "bob@example.com"},
+	})
+	if !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("testDb.InsertMany want ErrUniqueViolation, got: %v", err)
+	}
+
+	//reverted transaction must not leave values in the index
+	if err := testDb.Insert(&Customer{CustomerId: 4, Email: "bob@example.com"}); err != nil {
+		t.Errorf("testDb.Insert after reverted transaction failed: %s", err)
+	}
+}
+
+func TestInsertUniqueIndexChangedValues(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Customer", &Customer{})
+
+	if err := testDb.Insert(&Customer{CustomerId: 1, Email: "alice@example.com"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//a value changed by an update is free and the new value is taken
+	if err := testDb.Insert(&Customer{CustomerId: 1, Email: "carol@example.com"}); err != nil {
+		t.Fatalf("testDb.Insert update failed: %s", err)
+	}
+
+	if err := testDb.Insert(&Customer{CustomerId: 2, Email: "alice@example.com"}); err != nil {
+		t.Errorf("testDb.Insert of a value no longer held failed: %s", err)
+	}
+
+	if err := testDb.Insert(&Customer{CustomerId: 3, Email: "carol@example.com"}); !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("testDb.Insert want ErrUniqueViolation, got: %v", err)
+	}
+
+	//the value of a deleted record is free
+	if err := testDb.Delete("Customer/b0/2"); err != nil {
+		t.Fatalf("testDb.Delete failed: %s", err)
+	}
+
+	if err := testDb.Insert(&Customer{CustomerId: 3, Email: "alice@example.com"}); err != nil {
+		t.Errorf("testDb.Insert of the value of a deleted record failed: %s", err)
+	}
+}
+
+func TestGetAfterBlockChanged(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Note", &Note{})
+
+	for i := 1; i <= 3; i++ {
+		if err := testDb.Insert(&Note{NoteId: i, Text: fmt.Sprintf("Hello %d", i)}); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	result := &Note{}
+	if err := testDb.Get("Note/b0/2", result); err != nil || result.Text != "Hello 2" {
+		t.Errorf("testDb.Get want: %s, got: %s (%v)", "Hello 2", result.Text, err)
+	}
+
+	//change block behind gitdb's back so record positions are stale
+	blockFile := filepath.Join(dbPath, "data", "Note", "b0.json")
+	data, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+
+	data = bytes.Replace(data, []byte("Hello 1"), []byte("Hello there 1"), 1)
+	data = bytes.Replace(data, []byte("Hello 2"), []byte("Hello there 2"), 1)
+	if err := ioutil.WriteFile(blockFile, data, 0744); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	for i, want := range []string{"Hello there 2", "Hello 3"} {
+		result := &Note{}
+		recordID := fmt.Sprintf("Note/b0/%d", i+2)
+		if err := testDb.Get(recordID, result); err != nil {
+			t.Errorf("testDb.Get failed: %s", err)
+		}
+
+		if result.Text != want {
+			t.Errorf("testDb.Get(%s) want: %s, got: %s", recordID, want, result.Text)
+		}
+	}
+}
+
 func TestDeleteOrFail(t *testing.T) {
 	teardown := setup(t, nil)
 	defer teardown(t)
@@ -100,3 +491,118 @@ func TestGetLockFileNames(t *testing.T) {
 		t.Errorf("testMessage return %d lock files", len(locks))
 	}
 }
+
+func TestInsertLinesBlockFormat(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessage()); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//switch an existing dataset of JSON blocks to lines
+	testDb.Close()
+	cfg := getConfig()
+	cfg.BlockFormat = gitdb.BlockFormatLines
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	m := getTestMessage()
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(cfg.DBPath, "data", "Message", "b0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "Message/b0/0\t") {
+		t.Errorf("block should hold one line per record, got: %s", b)
+	}
+
+	result := &Message{}
+	if err := testDb.Get(gitdb.ID(m), result); err != nil || result.MessageId != m.MessageId {
+		t.Errorf("testDb.Get failed: %v", err)
+	}
+
+	records, err := testDb.Search("Message", []*gitdb.SearchParam{{Index: "From", Value: "alice@example.com"}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 4 {
+		t.Errorf("testDb.Search want: 4 records, got: %d (%v)", len(records), err)
+	}
+
+	//records decrypted by reads are written back encrypted
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	if b, _ := ioutil.ReadFile(filepath.Join(cfg.DBPath, "data", "Message", "b0.json")); strings.Contains(string(b), m.From) {
+		t.Errorf("block holds decrypted records: %s", b)
+	}
+
+	//blocks written as lines are read back by a JSON connection
+	testDb.Close()
+	testDb = getDbConn(t, getConfig())
+	testDb.RegisterModel("Message", &Message{})
+
+	records, err = testDb.Fetch("Message")
+	if err != nil || len(records) != 4 {
+		t.Errorf("testDb.Fetch want: 4 records, got: %d (%v)", len(records), err)
+	}
+}
+
+func TestInsertCompressedBlocks(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("LogEntry", &LogEntry{})
+
+	for i := 1; i <= 2; i++ {
+		if err := testDb.Insert(&LogEntry{EntryId: i, Text: fmt.Sprintf("entry %d", i)}); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//roll out compression to new blocks of an existing dataset
+	testDb.Close()
+	cfg := getConfig()
+	cfg.BlockCodec = gitdb.BlockCodecGzip
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("LogEntry", &LogEntry{})
+
+	for i := 3; i <= 4; i++ {
+		if err := testDb.Insert(&LogEntry{EntryId: i, Text: fmt.Sprintf("entry %d", i)}); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	for block, compressed := range map[string]bool{"2020": false, "2020~1": true} {
+		b, err := ioutil.ReadFile(filepath.Join(cfg.DBPath, "data", "LogEntry", block+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := bytes.HasPrefix(b, []byte{0x1f, 0x8b}); got != compressed {
+			t.Errorf("block %s compressed want: %v, got: %v", block, compressed, got)
+		}
+	}
+
+	records, err := testDb.Fetch("LogEntry")
+	if err != nil || len(records) != 4 {
+		t.Errorf("testDb.Fetch want: 4 records, got: %d (%v)", len(records), err)
+	}
+
+	entry := &LogEntry{}
+	for i := 0; i < 2; i++ {
+		if err := testDb.Get("LogEntry/2020~1/4", entry); err != nil || entry.Text != "entry 4" {
+			t.Errorf("testDb.Get want: %s, got: %s (%v)", "entry 4", entry.Text, err)
+		}
+	}
+
+	records, err = testDb.Search("LogEntry", []*gitdb.SearchParam{{Index: "Text", Value: "entry 3"}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Search want: 1 record, got: %d (%v)", len(records), err)
+	}
+}